@@ -0,0 +1,74 @@
+// Command argon2bench picks argon2id parameters that hit a target hashing
+// latency on the current machine & prints them as ARGON2_* env vars.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/alexedwards/argon2id"
+)
+
+func main() {
+	target := flag.Duration("target", 250*time.Millisecond, "target time per hash")
+	maxMemory := flag.Uint("max-memory", 1024*1024, "maximum memory per hash (KiB)")
+	minMemory := flag.Uint("min-memory", 19*1024, "starting memory per hash (KiB)")
+	parallelism := flag.Uint("parallelism", uint(runtime.NumCPU()), "threads per hash")
+	samples := flag.Int("samples", 3, "hashes timed per candidate")
+	flag.Parse()
+
+	if *parallelism < 1 || *parallelism > 255 {
+		fmt.Println("parallelism must be between 1 and 255")
+		os.Exit(1)
+	}
+
+	p := &argon2id.Params{
+		Memory:      uint32(*minMemory),
+		Iterations:  1,
+		Parallelism: uint8(*parallelism),
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+
+	fmt.Printf("Target: %s per hash, %d thread(s)\n\n", *target, p.Parallelism)
+
+	// Memory first (the RFC recommends t=1 with as much memory as possible)
+	d := measure(p, *samples)
+	for d < *target && uint(p.Memory)*2 <= *maxMemory {
+		p.Memory *= 2
+		d = measure(p, *samples)
+	}
+
+	// Then iterations until the target is reached
+	for d < *target {
+		p.Iterations++
+		d = measure(p, *samples)
+	}
+
+	fmt.Printf("\nChosen: m=%d KiB, t=%d, p=%d (%s)\n\n", p.Memory, p.Iterations, p.Parallelism, d)
+	fmt.Printf("ARGON2_MEMORY=%d\n", p.Memory)
+	fmt.Printf("ARGON2_ITERATIONS=%d\n", p.Iterations)
+	fmt.Printf("ARGON2_PARALLELISM=%d\n", p.Parallelism)
+	fmt.Printf("ARGON2_SALT_LENGTH=%d\n", p.SaltLength)
+	fmt.Printf("ARGON2_KEY_LENGTH=%d\n", p.KeyLength)
+}
+
+// measure - Average time to hash a password with the given params
+func measure(p *argon2id.Params, samples int) time.Duration {
+	if samples < 1 {
+		samples = 1
+	}
+	start := time.Now()
+	for i := 0; i < samples; i++ {
+		if _, err := argon2id.CreateHash("benchmark-password", p); err != nil {
+			fmt.Println("hash failed: " + err.Error())
+			os.Exit(1)
+		}
+	}
+	d := time.Since(start) / time.Duration(samples)
+	fmt.Printf("  m=%-8d t=%-3d p=%-3d %s\n", p.Memory, p.Iterations, p.Parallelism, d)
+	return d
+}
@@ -0,0 +1,269 @@
+// Command importusers bulk imports users (with password hashes from another
+// system) from a CSV or JSONL file.
+//
+// Each row needs email, name & password_hash. algorithm is detected from the
+// hash when empty, email_verified defaults to false. Legacy hashes are kept as
+// they are and upgraded to argon2id on the user's first successful login.
+package main
+
+import (
+	"app/helpers/password"
+	"app/helpers/users"
+	"app/utils"
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/sony/sonyflake"
+)
+
+type row struct {
+	Line          int
+	Email         string `json:"email"`
+	Name          string `json:"name"`
+	PasswordHash  string `json:"password_hash"`
+	Algorithm     string `json:"algorithm"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+type failure struct {
+	line   int
+	email  string
+	reason string
+}
+
+func main() {
+	file := flag.String("file", "", "CSV or JSONL file to import")
+	format := flag.String("format", "", "csv or jsonl (detected from the extension when empty)")
+	dryRun := flag.Bool("dry-run", false, "validate rows without writing to the DB")
+	flag.Parse()
+
+	if *file == "" {
+		fmt.Println("usage: importusers -file users.csv [-format csv|jsonl] [-dry-run]")
+		os.Exit(2)
+	}
+	if *format == "" {
+		*format = strings.TrimPrefix(strings.ToLower(filepath.Ext(*file)), ".")
+	}
+
+	_ = godotenv.Load()
+
+	f, err := os.Open(*file)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	var rows []row
+	var failures []failure
+	switch *format {
+	case "csv":
+		rows, failures, err = readCSV(f)
+	case "jsonl", "ndjson":
+		rows, failures, err = readJSONL(f)
+	default:
+		err = fmt.Errorf("unknown format %q", *format)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var db *sql.DB
+	var sf *sonyflake.Sonyflake
+	if !*dryRun {
+		machineID, err := strconv.ParseUint(os.Getenv("MACHINE_ID"), 16, 64)
+		if err != nil {
+			fmt.Println("MACHINE_ID must be hex (e.g. 01AF)")
+			os.Exit(1)
+		}
+		sf = sonyflake.NewSonyflake(sonyflake.Settings{
+			MachineID: func() (uint16, error) { return uint16(machineID), nil },
+		})
+		if sf == nil {
+			fmt.Println("failed to init sonyflake")
+			os.Exit(1)
+		}
+		db = utils.InitDb()
+		defer func() {
+			_ = db.Close()
+		}()
+	}
+
+	imported := 0
+	for _, rw := range rows {
+		if err := importRow(db, sf, rw, *dryRun); err != nil {
+			failures = append(failures, failure{rw.Line, rw.Email, err.Error()})
+			continue
+		}
+		imported++
+	}
+
+	for _, fl := range failures {
+		_, _ = fmt.Fprintf(os.Stderr, "line %d (%s): %s\n", fl.line, fl.email, fl.reason)
+	}
+	fmt.Printf("%d imported, %d failed\n", imported, len(failures))
+	if len(failures) > 0 {
+		os.Exit(1)
+	}
+}
+
+// importRow - Validates a row & inserts the user
+func importRow(db *sql.DB, sf *sonyflake.Sonyflake, rw row, dryRun bool) error {
+	email := strings.TrimSpace(strings.ToLower(rw.Email))
+	if _, err := mail.ParseAddress(email); err != nil || len(email) > 254 {
+		return errors.New("invalid email")
+	}
+	if len(rw.Name) > 64 {
+		return errors.New("name longer than 64 characters")
+	}
+
+	algo, hash, err := password.Identify(strings.TrimSpace(rw.PasswordHash))
+	if err != nil {
+		return err
+	}
+	if rw.Algorithm != "" && rw.Algorithm != algo {
+		return fmt.Errorf("hash looks like %s, not %s", algo, rw.Algorithm)
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	id, err := sf.NextID()
+	if err != nil {
+		return err
+	}
+
+	status := users.StatusUnverified
+	if rw.EmailVerified {
+		status = users.StatusActive
+	}
+
+	// The address lock keeps a registration for the same mailbox from racing the import
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	if err = users.LockEmail(tx, email); err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`
+	WITH u AS (
+		INSERT INTO users (id, name, email, password_hash, password_algo, email_verified, status)
+		SELECT $1, $2, $3, $4, $5, $6, $7
+		WHERE NOT EXISTS (
+			SELECT 1 FROM user_emails
+			WHERE (email = $3 OR canonical_email = $8) AND (verified OR is_primary)
+		)
+		ON CONFLICT (email) DO NOTHING
+		RETURNING id, email, email_verified
+	)
+	INSERT INTO user_emails (user_id, email, canonical_email, verified, is_primary, verified_at)
+	SELECT id, email, $8, email_verified, TRUE, CASE WHEN email_verified THEN NOW() END FROM u`,
+		id, rw.Name, email, hash, algo, rw.EmailVerified, status, users.CanonicalEmail(email))
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("email already exists")
+	}
+	return tx.Commit()
+}
+
+// readCSV - Reads rows from a CSV file with a header line
+func readCSV(r io.Reader) ([]row, []failure, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, err
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		cols[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+	for _, required := range []string{"email", "name", "password_hash"} {
+		if _, ok := cols[required]; !ok {
+			return nil, nil, fmt.Errorf("missing %q column", required)
+		}
+	}
+	get := func(rec []string, col string) string {
+		if i, ok := cols[col]; ok && i < len(rec) {
+			return rec[i]
+		}
+		return ""
+	}
+
+	var rows []row
+	var failures []failure
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			failures = append(failures, failure{line, "", err.Error()})
+			continue
+		}
+
+		rw := row{
+			Line:         line,
+			Email:        get(rec, "email"),
+			Name:         get(rec, "name"),
+			PasswordHash: get(rec, "password_hash"),
+			Algorithm:    get(rec, "algorithm"),
+		}
+		if v := get(rec, "email_verified"); v != "" {
+			rw.EmailVerified, err = strconv.ParseBool(v)
+			if err != nil {
+				failures = append(failures, failure{line, rw.Email, "invalid email_verified"})
+				continue
+			}
+		}
+		rows = append(rows, rw)
+	}
+	return rows, failures, nil
+}
+
+// readJSONL - Reads rows from a file with one JSON object per line
+func readJSONL(r io.Reader) ([]row, []failure, error) {
+	var rows []row
+	var failures []failure
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		var rw row
+		if err := json.Unmarshal([]byte(text), &rw); err != nil {
+			failures = append(failures, failure{line, "", "invalid JSON: " + err.Error()})
+			continue
+		}
+		rw.Line = line
+		rows = append(rows, rw)
+	}
+	return rows, failures, sc.Err()
+}
@@ -0,0 +1,3 @@
+ALTER TABLE password_history DROP COLUMN IF EXISTS password_algo;
+
+ALTER TABLE users DROP COLUMN IF EXISTS password_algo;
\ No newline at end of file
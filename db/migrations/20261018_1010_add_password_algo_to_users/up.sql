@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_algo VARCHAR(32) NOT NULL DEFAULT 'argon2id';
+
+ALTER TABLE password_history ADD COLUMN IF NOT EXISTS password_algo VARCHAR(32) NOT NULL DEFAULT 'argon2id';
\ No newline at end of file
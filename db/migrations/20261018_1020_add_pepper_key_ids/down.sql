@@ -0,0 +1,7 @@
+ALTER TABLE email_change_tokens DROP COLUMN IF EXISTS token_key_id;
+ALTER TABLE reset_tokens DROP COLUMN IF EXISTS token_key_id;
+ALTER TABLE verification_tokens DROP COLUMN IF EXISTS token_key_id;
+ALTER TABLE sessions DROP COLUMN IF EXISTS token_key_id;
+
+ALTER TABLE password_history DROP COLUMN IF EXISTS password_key_id;
+ALTER TABLE users DROP COLUMN IF EXISTS password_key_id;
\ No newline at end of file
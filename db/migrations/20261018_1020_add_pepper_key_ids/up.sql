@@ -0,0 +1,7 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_key_id VARCHAR(32) NOT NULL DEFAULT '';
+ALTER TABLE password_history ADD COLUMN IF NOT EXISTS password_key_id VARCHAR(32) NOT NULL DEFAULT '';
+
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS token_key_id VARCHAR(32) NOT NULL DEFAULT '';
+ALTER TABLE verification_tokens ADD COLUMN IF NOT EXISTS token_key_id VARCHAR(32) NOT NULL DEFAULT '';
+ALTER TABLE reset_tokens ADD COLUMN IF NOT EXISTS token_key_id VARCHAR(32) NOT NULL DEFAULT '';
+ALTER TABLE email_change_tokens ADD COLUMN IF NOT EXISTS token_key_id VARCHAR(32) NOT NULL DEFAULT '';
\ No newline at end of file
@@ -0,0 +1,7 @@
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_status_check;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS is_admin,
+    DROP COLUMN IF EXISTS status_changed_at,
+    DROP COLUMN IF EXISTS status_reason,
+    DROP COLUMN IF EXISTS status;
\ No newline at end of file
@@ -0,0 +1,11 @@
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS status VARCHAR(16) NOT NULL DEFAULT 'active',
+    ADD COLUMN IF NOT EXISTS status_reason TEXT,
+    ADD COLUMN IF NOT EXISTS status_changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    ADD COLUMN IF NOT EXISTS is_admin BOOL NOT NULL DEFAULT FALSE;
+
+UPDATE users SET status = 'unverified' WHERE email_verified = FALSE;
+UPDATE users SET status = 'deleted', status_changed_at = deleted_at WHERE deleted_at IS NOT NULL;
+
+ALTER TABLE users ADD CONSTRAINT users_status_check
+    CHECK (status IN ('active', 'unverified', 'suspended', 'locked', 'deleted'));
\ No newline at end of file
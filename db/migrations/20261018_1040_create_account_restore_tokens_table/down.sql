@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN IF EXISTS purged_at;
+
+DROP TABLE IF EXISTS account_restore_tokens;
\ No newline at end of file
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS account_restore_tokens (
+    user_id BIGINT PRIMARY KEY,
+    token_hash BYTEA NOT NULL,
+    token_key_id VARCHAR(32) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS purged_at TIMESTAMPTZ;
\ No newline at end of file
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS data_exports (
+    id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    status VARCHAR(16) NOT NULL DEFAULT 'pending',
+    token_hash BYTEA UNIQUE,
+    token_key_id VARCHAR(32) NOT NULL DEFAULT '',
+    archive JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS data_exports_pending_idx ON data_exports (created_at) WHERE status = 'pending';
\ No newline at end of file
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS audit_events (
+    id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+    actor_id BIGINT,
+    subject_id BIGINT,
+    event_type VARCHAR(64) NOT NULL,
+    ip VARCHAR(64),
+    user_agent TEXT,
+    request_id VARCHAR(128),
+    metadata JSONB NOT NULL DEFAULT '{}'::jsonb,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_subject_idx ON audit_events (subject_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS audit_events_actor_idx ON audit_events (actor_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS audit_events_type_idx ON audit_events (event_type, created_at DESC, id DESC);
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS security_lock_tokens;
\ No newline at end of file
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS security_lock_tokens (
+    id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    token_hash BYTEA NOT NULL UNIQUE,
+    token_key_id VARCHAR(32) NOT NULL DEFAULT '',
+    event_type VARCHAR(64) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS security_lock_tokens_user_id_idx ON security_lock_tokens (user_id);
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS email_change_reverts (
+    id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    old_email VARCHAR(254) NOT NULL,
+    new_email VARCHAR(254) NOT NULL,
+    token_hash BYTEA NOT NULL UNIQUE,
+    token_key_id VARCHAR(32) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS email_change_reverts_user_id_idx ON email_change_reverts (user_id);
+CREATE INDEX IF NOT EXISTS email_change_reverts_old_email_idx ON email_change_reverts (old_email, created_at);
\ No newline at end of file
@@ -0,0 +1,2 @@
+-- Last password (or 2FA) confirmation on this session, login counts as one
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS reauthenticated_at TIMESTAMPTZ;
\ No newline at end of file
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS user_email_tokens;
+DROP TABLE IF EXISTS user_emails;
\ No newline at end of file
@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS user_emails (
+    id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+    user_id BIGINT NOT NULL,
+    email VARCHAR(254) NOT NULL,
+    verified BOOL NOT NULL DEFAULT FALSE,
+    is_primary BOOL NOT NULL DEFAULT FALSE,
+    verified_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, email),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- An address belongs to one account once it's verified (or primary), unverified extras can't squat it
+CREATE UNIQUE INDEX IF NOT EXISTS user_emails_email_idx ON user_emails (email) WHERE verified OR is_primary;
+CREATE UNIQUE INDEX IF NOT EXISTS user_emails_primary_idx ON user_emails (user_id) WHERE is_primary;
+
+CREATE TABLE IF NOT EXISTS user_email_tokens (
+    email_id UUID PRIMARY KEY,
+    token_hash BYTEA NOT NULL UNIQUE,
+    token_key_id VARCHAR(32) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (email_id) REFERENCES user_emails(id) ON DELETE CASCADE
+);
+
+-- users.email stays as the primary address
+INSERT INTO user_emails (user_id, email, verified, is_primary, verified_at)
+SELECT id, email, email_verified, TRUE, CASE WHEN email_verified THEN NOW() END
+FROM users
+WHERE purged_at IS NULL
+ON CONFLICT DO NOTHING;
\ No newline at end of file
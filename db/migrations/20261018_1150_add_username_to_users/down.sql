@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS users_username_idx;
+
+ALTER TABLE users DROP COLUMN IF EXISTS username;
\ No newline at end of file
@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS username VARCHAR(64);
+
+-- Case-insensitive, NULLs (no username) don't clash
+CREATE UNIQUE INDEX IF NOT EXISTS users_username_idx ON users (LOWER(username));
\ No newline at end of file
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS sms_challenges;
+
+DROP INDEX IF EXISTS users_phone_idx;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS sms_2fa_enabled,
+    DROP COLUMN IF EXISTS phone;
\ No newline at end of file
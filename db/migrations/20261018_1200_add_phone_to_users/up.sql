@@ -0,0 +1,19 @@
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS phone VARCHAR(16),
+    ADD COLUMN IF NOT EXISTS sms_2fa_enabled BOOL NOT NULL DEFAULT FALSE;
+
+-- E.164, only set once verified by SMS
+CREATE UNIQUE INDEX IF NOT EXISTS users_phone_idx ON users (phone);
+
+CREATE TABLE IF NOT EXISTS sms_challenges (
+    user_id BIGINT NOT NULL,
+    kind VARCHAR(16) NOT NULL,
+    phone VARCHAR(16) NOT NULL,
+    token_hash BYTEA NOT NULL UNIQUE,
+    code_hash BYTEA NOT NULL,
+    token_key_id VARCHAR(32) NOT NULL DEFAULT '',
+    attempts INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, kind),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
\ No newline at end of file
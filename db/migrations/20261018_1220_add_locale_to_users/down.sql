@@ -0,0 +1,6 @@
+ALTER TABLE email_outbox
+    ADD COLUMN IF NOT EXISTS subject TEXT NOT NULL DEFAULT '',
+    DROP COLUMN IF EXISTS locale;
+
+ALTER TABLE users
+    DROP COLUMN IF EXISTS locale;
\ No newline at end of file
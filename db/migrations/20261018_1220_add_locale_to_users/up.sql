@@ -0,0 +1,7 @@
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS locale VARCHAR(8) NOT NULL DEFAULT 'en';
+
+-- Emails are written in the recipient's locale, their subject comes from the template
+ALTER TABLE email_outbox
+    ADD COLUMN IF NOT EXISTS locale VARCHAR(8) NOT NULL DEFAULT 'en',
+    DROP COLUMN IF EXISTS subject;
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS email_suppressions;
\ No newline at end of file
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS email_suppressions (
+    email VARCHAR(254) PRIMARY KEY,
+    reason VARCHAR(16) NOT NULL, -- bounce, complaint
+    detail TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Outbox emails to suppressed addresses end up 'skipped'
\ No newline at end of file
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS email_change_reverts_old_canonical_email_idx;
+
+ALTER TABLE email_change_reverts
+    DROP COLUMN IF EXISTS old_canonical_email;
+
+DROP INDEX IF EXISTS user_emails_canonical_email_idx;
+
+ALTER TABLE user_emails
+    DROP COLUMN IF EXISTS canonical_email;
\ No newline at end of file
@@ -0,0 +1,12 @@
+-- users.email mirrors the primary row of user_emails, so the canonical form lives there.
+-- Filled by the app (jobs.BackfillCanonicalEmails for existing rows), not unique since older accounts may already collide.
+ALTER TABLE user_emails
+    ADD COLUMN IF NOT EXISTS canonical_email VARCHAR(254);
+
+CREATE INDEX IF NOT EXISTS user_emails_canonical_email_idx ON user_emails (canonical_email) WHERE verified OR is_primary;
+
+-- Addresses reserved for an email change revert are matched the same way (rows stored before are backfilled too)
+ALTER TABLE email_change_reverts
+    ADD COLUMN IF NOT EXISTS old_canonical_email VARCHAR(254);
+
+CREATE INDEX IF NOT EXISTS email_change_reverts_old_canonical_email_idx ON email_change_reverts (old_canonical_email, created_at);
\ No newline at end of file
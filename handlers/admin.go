@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"app/helpers/audit"
+	"app/helpers/logs"
+	"app/helpers/users"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// requireAdmin - Gets the user ID from the token & makes sure the user is an admin
+func requireAdmin(w http.ResponseWriter, r *http.Request, db *sql.DB) (int64, bool) {
+	// Get token
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return 0, false
+	}
+
+	// Get user ID from token
+	userID, err := users.GetId(token, w, db)
+	if err != nil {
+		return 0, false
+	}
+
+	// Check the admin flag
+	var isAdmin bool
+	err = db.QueryRow(`SELECT is_admin FROM users WHERE id = $1`, userID).Scan(&isAdmin)
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to query the DB.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return 0, false
+	}
+	if !isAdmin {
+		w.WriteHeader(http.StatusForbidden)
+		return 0, false
+	}
+
+	return userID, true
+}
+
+// UpdateUserStatusHandler - Lets an admin suspend, lock, delete or reactivate an account
+func UpdateUserStatusHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	adminID, ok := requireAdmin(w, r, db)
+	if !ok {
+		return
+	}
+
+	// Get the target user's ID from the URL
+	userID, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Payload
+	type Payload struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}
+	var p Payload
+
+	// Decode
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&p)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Validate ("unverified" is only set by registration)
+	if !users.ValidStatus(p.Status) || p.Status == users.StatusUnverified || len(p.Reason) > 1024 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Update the status (revokes sessions unless the account becomes active)
+	err = users.SetStatus(db, userID, p.Status, p.Reason)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to update the user's status.",
+			err,
+			map[string]any{
+				"route":    r.URL.Path,
+				"payload":  p,
+				"admin_id": adminID,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	audit.Log(db, r, audit.StatusChanged, adminID, userID, map[string]any{
+		"status": p.Status,
+		"reason": p.Reason,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"app/helpers/logs"
+	"app/helpers/users"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// auditEvent - A row of audit_events as returned by the API
+type auditEvent struct {
+	ID        string          `json:"id"`
+	ActorID   *string         `json:"actor_id,omitempty"`
+	SubjectID *string         `json:"subject_id,omitempty"`
+	Type      string          `json:"type"`
+	IP        *string         `json:"ip"`
+	UserAgent *string         `json:"user_agent"`
+	RequestID *string         `json:"request_id,omitempty"`
+	Metadata  json.RawMessage `json:"metadata"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// auditFilter - Optional conditions for queryAuditEvents, zero values are ignored
+type auditFilter struct {
+	ActorID   int64
+	SubjectID int64
+	Type      string
+	IP        string
+	From      time.Time
+	To        time.Time
+	Before    auditCursor
+	Limit     int
+}
+
+// auditCursor - Position of the last event of a page: events sharing its timestamp are told apart by id
+type auditCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// auditCursorID - A canonical UUID as written by Postgres
+var auditCursorID = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// String - The cursor as returned in next_before: <created_at RFC 3339>_<id>
+func (c auditCursor) String() string {
+	return c.CreatedAt.UTC().Format(time.RFC3339Nano) + "_" + c.ID
+}
+
+// parseAuditCursor - Reads a next_before value
+func parseAuditCursor(s string) (auditCursor, bool) {
+	ts, id, ok := strings.Cut(s, "_")
+	if !ok || !auditCursorID.MatchString(id) {
+		return auditCursor{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return auditCursor{}, false
+	}
+	return auditCursor{CreatedAt: t, ID: id}, true
+}
+
+// parseAuditPaging - Reads ?limit= (default 50, max 200) & the ?before= cursor (next_before of the previous page)
+func parseAuditPaging(r *http.Request, f *auditFilter) bool {
+	f.Limit = 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 200 {
+			return false
+		}
+		f.Limit = n
+	}
+	if v := r.URL.Query().Get("before"); v != "" {
+		c, ok := parseAuditCursor(v)
+		if !ok {
+			return false
+		}
+		f.Before = c
+	}
+	return true
+}
+
+// queryAuditEvents - Newest first, up to f.Limit events matching f
+func queryAuditEvents(db *sql.DB, f auditFilter) ([]auditEvent, error) {
+	var where []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.ActorID != 0 {
+		add("actor_id = $%d", f.ActorID)
+	}
+	if f.SubjectID != 0 {
+		add("subject_id = $%d", f.SubjectID)
+	}
+	if f.Type != "" {
+		add("event_type = $%d", f.Type)
+	}
+	if f.IP != "" {
+		add("ip = $%d", f.IP)
+	}
+	if !f.From.IsZero() {
+		add("created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("created_at < $%d", f.To)
+	}
+	if f.Before.ID != "" {
+		args = append(args, f.Before.CreatedAt, f.Before.ID)
+		where = append(where, fmt.Sprintf("(created_at, id) < ($%d, $%d::uuid)", len(args)-1, len(args)))
+	}
+
+	query := `
+		SELECT id, actor_id::text, subject_id::text, event_type, ip, user_agent, request_id, metadata, created_at
+		FROM audit_events`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, f.Limit)
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args))
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	events := []auditEvent{}
+	for rows.Next() {
+		var e auditEvent
+		var meta []byte
+		err = rows.Scan(&e.ID, &e.ActorID, &e.SubjectID, &e.Type, &e.IP, &e.UserAgent, &e.RequestID, &meta, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		e.Metadata = meta
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// writeAuditEvents - Returns the events & the cursor for the next page
+func writeAuditEvents(w http.ResponseWriter, r *http.Request, db *sql.DB, events []auditEvent, limit int, userID int64) {
+	resp := map[string]any{
+		"events": events,
+	}
+	if len(events) == limit {
+		last := events[len(events)-1]
+		resp["next_before"] = auditCursor{CreatedAt: last.CreatedAt, ID: last.ID}.String()
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logs.Err(
+			db,
+			"Return err",
+			"Failed to return the data.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			userID,
+		)
+	}
+}
+
+// SecurityEventsHandler - Lists the events concerning the user's own account
+func SecurityEventsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	// Get token
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Get user ID from token
+	userID, err := users.GetId(token, w, db)
+	if err != nil {
+		return
+	}
+
+	// Paging
+	f := auditFilter{SubjectID: userID}
+	if !parseAuditPaging(r, &f) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Get the events
+	events, err := queryAuditEvents(db, f)
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to query the DB.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Users don't need to know which admin or request did it
+	for i := range events {
+		events[i].ActorID = nil
+		events[i].SubjectID = nil
+		events[i].RequestID = nil
+	}
+
+	writeAuditEvents(w, r, db, events, f.Limit, userID)
+}
+
+// AuditEventsHandler - Lets an admin search the audit log.
+// Filters: user_id (subject), actor_id, type, ip, from & to (RFC 3339), plus limit & before for paging.
+func AuditEventsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	adminID, ok := requireAdmin(w, r, db)
+	if !ok {
+		return
+	}
+
+	// Filters
+	var f auditFilter
+	q := r.URL.Query()
+	var err error
+	if v := q.Get("user_id"); v != "" {
+		if f.SubjectID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	if v := q.Get("actor_id"); v != "" {
+		if f.ActorID, err = strconv.ParseInt(v, 10, 64); err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	if v := q.Get("from"); v != "" {
+		if f.From, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if f.To, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+	}
+	f.Type = q.Get("type")
+	f.IP = q.Get("ip")
+	if !parseAuditPaging(r, &f) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Get the events
+	events, err := queryAuditEvents(db, f)
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to query the DB.",
+			err,
+			map[string]any{
+				"route":  r.URL.Path,
+				"filter": f,
+			},
+			adminID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	writeAuditEvents(w, r, db, events, f.Limit, adminID)
+}
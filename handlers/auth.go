@@ -1,14 +1,17 @@
 package handlers
 
 import (
+	"app/helpers/audit"
 	email2 "app/helpers/email"
+	"app/helpers/i18n"
 	"app/helpers/logs"
+	"app/helpers/password"
+	"app/helpers/pepper"
 	"app/helpers/users"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"net/mail"
 	"net/url"
@@ -17,7 +20,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/alexedwards/argon2id"
 	"github.com/go-chi/chi/v5"
 	"github.com/matoous/go-nanoid/v2"
 	"github.com/sony/sonyflake"
@@ -50,13 +52,25 @@ func RegistrationHandler(w http.ResponseWriter, r *http.Request, sf *sonyflake.S
 	}
 
 	// Validate
-	if len(p.Name) > 64 || len(email) > 254 || len(p.Password) < 8 {
+	if len(p.Name) > 64 || len(email) > 254 {
 		w.WriteHeader(http.StatusUnprocessableEntity)
 		return
 	}
 
+	// Check the email domain policy
+	if rule := users.CheckEmailDomain(r.Context(), email); rule != "" {
+		writeEmailDomainViolation(w, r, rule)
+		return
+	}
+
+	// Check the password policy
+	if v := password.Check(p.Password, password.Inputs{Email: email, Name: p.Name}); len(v) > 0 {
+		writePasswordViolations(w, r, v)
+		return
+	}
+
 	// Hash the password
-	hash, err := argon2id.CreateHash(p.Password, argon2id.DefaultParams)
+	hash, err := password.Hash(p.Password)
 	if err != nil {
 		logs.Err(
 			db,
@@ -91,15 +105,13 @@ func RegistrationHandler(w http.ResponseWriter, r *http.Request, sf *sonyflake.S
 		return
 	}
 
-	// (Attempt to) store the user
-	res, err := db.Exec(`INSERT INTO users (id, name, email, password_hash)
-	VALUES ($1, $2, $3, $4)
-	ON CONFLICT (email) DO NOTHING`, id, p.Name, email, hash)
+	// Generate & hash verification token
+	rawToken, err := gonanoid.New(128)
 	if err != nil {
 		logs.Err(
 			db,
-			"User creation",
-			"Failed to insert the user into the database",
+			"Gonanoid generation",
+			"Gonanoid failed to generate the email verification token.",
 			err,
 			map[string]any{
 				"route": r.URL.Path,
@@ -110,21 +122,69 @@ func RegistrationHandler(w http.ResponseWriter, r *http.Request, sf *sonyflake.S
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	tokenHash := users.HashToken(rawToken)
 
-	// Check for email conflict
-	rows, _ := res.RowsAffected()
-	if rows == 0 {
-		w.WriteHeader(http.StatusConflict)
+	// The user, their token & the verification email are stored together
+	tx, err := db.Begin()
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to begin the transaction.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			0,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
 
-	// Generate & hash verification token
-	rawToken, err := gonanoid.New(128)
+	// Concurrent registrations for the same mailbox wait for each other, so the check below sees the first one
+	if err = users.LockEmail(tx, email); err != nil {
+		logs.Err(
+			db,
+			"User creation",
+			"Failed to lock the email address",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+				"email": email,
+			},
+			0,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// (Attempt to) store the user & their primary address
+	// (addresses verified on other accounts, or recently changed away from, are taken, however they're spelled)
+	res, err := tx.Exec(`
+	WITH u AS (
+		INSERT INTO users (id, name, email, password_hash, password_algo, password_key_id, status, locale)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $9
+		WHERE NOT EXISTS (
+			SELECT 1 FROM email_change_reverts
+			WHERE (old_email = $3 OR old_canonical_email = $10) AND created_at >= NOW() - make_interval(days => $8)
+		)
+		AND NOT EXISTS (
+			SELECT 1 FROM user_emails
+			WHERE (email = $3 OR canonical_email = $10) AND (verified OR is_primary)
+		)
+		ON CONFLICT (email) DO NOTHING
+		RETURNING id, email
+	)
+	INSERT INTO user_emails (user_id, email, canonical_email, is_primary)
+	SELECT id, email, $10, TRUE FROM u`, id, p.Name, email, hash.Hash, hash.Algo, hash.KeyID, users.StatusUnverified, users.EmailRevertDays(), i18n.FromRequest(r), users.CanonicalEmail(email))
 	if err != nil {
 		logs.Err(
 			db,
-			"Gonanoid generation",
-			"Gonanoid failed to generate the email verification token.",
+			"User creation",
+			"Failed to insert the user into the database",
 			err,
 			map[string]any{
 				"route": r.URL.Path,
@@ -135,15 +195,22 @@ func RegistrationHandler(w http.ResponseWriter, r *http.Request, sf *sonyflake.S
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	tokenHash := users.HashToken(rawToken)
+
+	// Check for email conflict
+	rows, _ := res.RowsAffected()
+	if rows == 0 {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
 
 	// Store the token
-	_, err = db.Exec(`INSERT INTO verification_tokens (user_id, token_hash)
-	VALUES ($1, $2)
+	_, err = tx.Exec(`INSERT INTO verification_tokens (user_id, token_hash, token_key_id)
+	VALUES ($1, $2, $3)
 	ON CONFLICT (user_id)
 	DO UPDATE SET
   		token_hash = EXCLUDED.token_hash,
-  		created_at = NOW()`, id, tokenHash)
+  		token_key_id = EXCLUDED.token_key_id,
+  		created_at = NOW()`, id, tokenHash, users.TokenKeyID())
 	if err != nil {
 		logs.Err(
 			db,
@@ -160,32 +227,37 @@ func RegistrationHandler(w http.ResponseWriter, r *http.Request, sf *sonyflake.S
 		return
 	}
 
-	// Send verification email
+	// Queue verification email
 	frontend := os.Getenv("FRONTEND_URL")
 	u := fmt.Sprintf("%s/auth/verify?token=%s", frontend, url.PathEscape(rawToken))
-	err = email2.SendVerification(email, u)
+	err = email2.SendVerification(tx, int64(id), email, u)
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		logs.Err(
 			db,
-			"Email sending error",
-			"Failed to send the verification email",
+			"Email queue error",
+			"Failed to queue the verification email",
 			err,
 			map[string]any{
 				"route": r.URL.Path,
 				"email": email,
 			},
-			int64(id),
+			0,
 		)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	audit.Log(db, r, audit.Registered, int64(id), int64(id), map[string]any{"email": email})
 
 	w.WriteHeader(http.StatusCreated)
 }
 
 func LoginHandler(w http.ResponseWriter, r *http.Request, sf *sonyflake.Sonyflake, db *sql.DB) {
-	// Payload
+	// Payload ("login" takes an email or a username, "email" is kept for older clients)
 	type Payload struct {
+		Login    string `json:"login"`
 		Email    string `json:"email"`
 		Password string `json:"password"`
 	}
@@ -199,29 +271,68 @@ func LoginHandler(w http.ResponseWriter, r *http.Request, sf *sonyflake.Sonyflak
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	login := strings.TrimSpace(p.Login)
+	if login == "" {
+		login = strings.TrimSpace(p.Email)
+	}
 
 	// Validate
-	if len(p.Email) > 254 || len(p.Password) < 8 {
+	if login == "" || len(login) > 254 || p.Password == "" {
 		w.WriteHeader(http.StatusUnprocessableEntity)
 		return
 	}
 
-	// Format email
-	email := strings.TrimSpace(strings.ToLower(p.Email))
-	_, err = mail.ParseAddress(email)
-	if err != nil {
-		w.WriteHeader(http.StatusUnprocessableEntity)
-		return
+	// Format email (usernames can't contain "@")
+	byUsername := !strings.Contains(login, "@")
+	email := strings.ToLower(login)
+	if !byUsername {
+		_, err = mail.ParseAddress(email)
+		if err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
 	}
 
-	// Get the user's ID, password_hash and email_verified
+	// Get the user's ID, primary email, password hash, email_verified, status & 2FA settings
+	// (any verified address logs in, the primary one even before verification, spelled any way that reaches
+	// the same mailbox; the exact address wins if older accounts share the mailbox)
+	query := `
+		SELECT u.id, u.email, u.password_algo, u.password_key_id, u.password_hash, u.email_verified, u.status,
+		       u.sms_2fa_enabled, COALESCE(u.phone, '')
+		FROM user_emails e
+		JOIN users u ON u.id = e.user_id
+		WHERE (e.email = $1 OR e.canonical_email = $2) AND (e.verified OR e.is_primary) AND u.status <> 'deleted'
+		ORDER BY e.email = $1 DESC, e.is_primary DESC
+		LIMIT 1`
+	args := []any{email, users.CanonicalEmail(email)}
+	if byUsername {
+		query = `
+		SELECT id, email, password_algo, password_key_id, password_hash, email_verified, status,
+		       sms_2fa_enabled, COALESCE(phone, '')
+		FROM users
+		WHERE LOWER(username) = $1 AND status <> 'deleted'`
+		args = []any{email}
+	}
 	var userID int64
-	var passwordHash string
+	var primaryEmail string
+	var stored password.Stored
 	var verified bool
-	err = db.QueryRow(`SELECT id, password_hash, email_verified FROM users WHERE email = $1`, email).
-		Scan(&userID, &passwordHash, &verified)
+	var status string
+	var twoFA bool
+	var phone string
+	err = db.QueryRow(query, args...).
+		Scan(&userID, &primaryEmail, &stored.Algo, &stored.KeyID, &stored.Hash, &verified, &status, &twoFA, &phone)
+	if errors.Is(err, sql.ErrNoRows) && byUsername {
+		audit.Log(db, r, audit.LoginFailed, 0, 0, map[string]any{"username": login, "reason": "unknown_username"})
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if byUsername {
+		email = primaryEmail
+	}
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
+			audit.Log(db, r, audit.LoginFailed, 0, 0, map[string]any{"email": email, "reason": "unknown_email"})
 			w.WriteHeader(http.StatusUnauthorized)
 		} else {
 			logs.Err(
@@ -241,34 +352,93 @@ func LoginHandler(w http.ResponseWriter, r *http.Request, sf *sonyflake.Sonyflak
 	}
 
 	// Check if password matches
-	match, err := argon2id.ComparePasswordAndHash(p.Password, passwordHash)
+	match, err := password.Verify(p.Password, stored)
 	if err != nil {
-		logs.Err(
-			db,
-			"Argon2id comparison",
-			"Argon2id failed to compare the passwords",
-			err,
-			map[string]any{
-				"route": r.URL.Path,
-				"email": email,
-			},
-			userID,
-		)
-		w.WriteHeader(http.StatusInternalServerError)
+		if errors.Is(err, pepper.ErrUnknownKey) {
+			audit.Log(db, r, audit.LoginFailed, 0, userID, map[string]any{"email": email, "reason": "retired_pepper_key"})
+		}
+		writePasswordVerifyError(w, r, db, userID, stored.KeyID, err)
 		return
 	}
 	if !match {
+		audit.Log(db, r, audit.LoginFailed, 0, userID, map[string]any{"email": email, "reason": "wrong_password"})
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
+	// Upgrade legacy hashes, old pepper keys & hashes created with weaker parameters
+	if password.NeedsRehash(stored) {
+		hash, err := password.Hash(p.Password)
+		if err == nil {
+			_, err = db.Exec(`
+				UPDATE users
+				SET password_hash = $1, password_algo = $2, password_key_id = $3
+				WHERE id = $4 AND password_hash = $5`,
+				hash.Hash, hash.Algo, hash.KeyID, userID, stored.Hash)
+		}
+		if err != nil {
+			logs.Err(
+				db,
+				"Password rehash err",
+				"Failed to upgrade the password hash",
+				err,
+				map[string]any{
+					"route": r.URL.Path,
+					"email": email,
+				},
+				userID,
+			)
+		} else {
+			audit.Log(db, r, audit.PasswordHashUpgraded, userID, userID, map[string]any{"from": stored.Algo})
+		}
+	}
+
 	// 403 if email not verified
-	if !verified {
+	if !verified || status == users.StatusUnverified {
+		audit.Log(db, r, audit.LoginFailed, 0, userID, map[string]any{"email": email, "reason": "unverified"})
 		w.WriteHeader(http.StatusForbidden)
 		return
 	}
 
+	// 403 if the account is suspended or locked
+	if status != users.StatusActive {
+		audit.Log(db, r, audit.LoginFailed, 0, userID, map[string]any{"email": email, "reason": "account_" + status})
+		writeError(w, r, http.StatusForbidden, "account_"+status)
+		return
+	}
+
+	// Second step by SMS when 2FA is on (no more than one code a minute)
+	if twoFA && phone != "" {
+		allowed, err := smsAllowed(db, userID, users.ChallengeTwoFA, phone)
+		if err != nil {
+			logs.Err(
+				db,
+				"DB err",
+				"Failed to query the DB.",
+				err,
+				map[string]any{
+					"route": r.URL.Path,
+				},
+				userID,
+			)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		startSMSChallenge(w, r, db, userID, users.ChallengeTwoFA, phone)
+		return
+	}
+
 	// Login successful
+	startSession(w, r, sf, db, userID, primaryEmail, "password")
+}
+
+// startSession - Creates a session for a user who proved who they are & returns its token.
+// method is how they logged in ("password", "2fa" or "sms").
+func startSession(w http.ResponseWriter, r *http.Request, sf *sonyflake.Sonyflake, db *sql.DB, userID int64, email string, method string) {
 
 	// Generate session token & hash it
 	rawToken, err := gonanoid.New(128)
@@ -309,8 +479,8 @@ func LoginHandler(w http.ResponseWriter, r *http.Request, sf *sonyflake.Sonyflak
 	id := strconv.FormatUint(idInt, 10)
 
 	// Store the session
-	_, err = db.Exec(`INSERT INTO sessions (id, user_id, token_hash)
-	VALUES ($1, $2, $3)`, id, userID, tokenHash)
+	_, err = db.Exec(`INSERT INTO sessions (id, user_id, token_hash, token_key_id, reauthenticated_at)
+	VALUES ($1, $2, $3, $4, NOW())`, id, userID, tokenHash, users.TokenKeyID())
 	if err != nil {
 		logs.Err(
 			db,
@@ -327,6 +497,28 @@ func LoginHandler(w http.ResponseWriter, r *http.Request, sf *sonyflake.Sonyflak
 		return
 	}
 
+	// Tell the user about logins from unknown devices
+	newDevice, err := isNewDevice(db, userID, r.UserAgent())
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to check the login device.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			userID,
+		)
+	}
+	if newDevice {
+		ip, userAgent, at := audit.ClientIP(r), r.UserAgent(), time.Now()
+		notifySecurityEvent(db, r, userID, audit.LoginSucceeded, func(q email2.Queuer, lockLink string) error {
+			return email2.SendNewLogin(q, userID, email, ip, userAgent, at, lockLink)
+		})
+	}
+	audit.Log(db, r, audit.LoginSucceeded, userID, userID, map[string]any{"session_id": id, "method": method})
+
 	// Return the unhashed token
 	w.WriteHeader(http.StatusOK)
 	if err = json.NewEncoder(w).Encode(map[string]interface{}{
@@ -353,10 +545,15 @@ func TokenCheckHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	rawToken := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
 
 	// Hash the token & check if it exists
-	token := users.HashToken(rawToken)
+	keyIDs, tokens := users.TokenHashes(rawToken)
 
 	var exists bool
-	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM sessions WHERE token_hash = $1)`, token).Scan(&exists)
+	err := db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM sessions s
+			JOIN users u ON u.id = s.user_id
+			WHERE (s.token_key_id, s.token_hash) IN (SELECT * FROM UNNEST($1::varchar[], $2::bytea[])) AND u.status = 'active'
+		)`, keyIDs, tokens).Scan(&exists)
 	if err != nil {
 		logs.Err(
 			db,
@@ -404,9 +601,16 @@ func SendPasswordResetHandler(w http.ResponseWriter, r *http.Request, db *sql.DB
 		return
 	}
 
-	// Get user ID from email
+	// Get user ID from any of their verified addresses (or the primary one), however it's spelled.
+	// The email goes to the address as stored.
 	var userID int64
-	err = db.QueryRow(`SELECT id FROM users WHERE email = $1`, email).Scan(&userID)
+	err = db.QueryRow(`
+		SELECT u.id, e.email
+		FROM user_emails e
+		JOIN users u ON u.id = e.user_id
+		WHERE (e.email = $1 OR e.canonical_email = $2) AND (e.verified OR e.is_primary) AND u.status <> 'deleted'
+		ORDER BY e.email = $1 DESC, e.is_primary DESC
+		LIMIT 1`, email, users.CanonicalEmail(email)).Scan(&userID, &email)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			w.WriteHeader(http.StatusNoContent) // Fake 204 if the user doesn't exist
@@ -474,18 +678,32 @@ func SendPasswordResetHandler(w http.ResponseWriter, r *http.Request, db *sql.DB
 	}
 	tokenHash := users.HashToken(rawToken)
 
-	// Store the token
-	_, err = db.Exec(`
-		INSERT INTO reset_tokens (user_id, token_hash)
-		VALUES ($1, $2)
-		ON CONFLICT (user_id)
-		DO UPDATE SET token_hash = EXCLUDED.token_hash, created_at = NOW()
-		`, userID, tokenHash)
+	// Store the token & queue the reset email together
+	frontend := os.Getenv("FRONTEND_URL")
+	u := fmt.Sprintf("%s/auth/reset?token=%s", frontend, url.PathEscape(rawToken))
+	tx, err := db.Begin()
+	if err == nil {
+		defer func() {
+			_ = tx.Rollback()
+		}()
+		_, err = tx.Exec(`
+			INSERT INTO reset_tokens (user_id, token_hash, token_key_id)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (user_id)
+			DO UPDATE SET token_hash = EXCLUDED.token_hash, token_key_id = EXCLUDED.token_key_id, created_at = NOW()
+			`, userID, tokenHash, users.TokenKeyID())
+	}
+	if err == nil {
+		err = email2.SendReset(tx, userID, email, u)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		logs.Err(
 			db,
 			"DB err",
-			"Failed to store the token in the DB",
+			"Failed to store the token & queue the reset email",
 			err,
 			map[string]any{
 				"route": r.URL.Path,
@@ -497,26 +715,7 @@ func SendPasswordResetHandler(w http.ResponseWriter, r *http.Request, db *sql.DB
 		return
 	}
 
-	// Send reset email
-	go func() {
-		frontend := os.Getenv("FRONTEND_URL")
-		u := fmt.Sprintf("%s/auth/reset?token=%s", frontend, url.PathEscape(rawToken))
-		err := email2.SendReset(email, u)
-		if err != nil {
-			logs.Err(
-				db,
-				"SMTP err",
-				"Failed to send mail",
-				err,
-				map[string]any{
-					"route": r.URL.Path,
-					"email": email,
-				},
-				userID,
-			)
-			return
-		}
-	}()
+	audit.Log(db, r, audit.PasswordResetRequested, 0, userID, nil)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -524,7 +723,7 @@ func SendPasswordResetHandler(w http.ResponseWriter, r *http.Request, db *sql.DB
 func PasswordResetHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	// Get token from URL & hash it
 	rawToken := chi.URLParam(r, "token")
-	tokenHash := users.HashToken(rawToken)
+	keyIDs, tokenHashes := users.TokenHashes(rawToken)
 
 	// Payload
 	type Payload struct {
@@ -544,20 +743,18 @@ func PasswordResetHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		_ = r.Body.Close()
 	}()
 
-	// Validate
-	if len(p.Password) < 8 {
-		w.WriteHeader(http.StatusUnprocessableEntity)
-		return
-	}
-
-	// Get user ID from token
+	// Get user ID, email, name & current password from token
 	var userID int64
+	var email, name string
+	var current password.Stored
 	err = db.QueryRow(`
-		SELECT user_id
-		FROM reset_tokens
-		WHERE token_hash = $1
-		AND created_at >= NOW() - INTERVAL '1 day'
-		`, tokenHash).Scan(&userID)
+		SELECT t.user_id, u.email, u.name, u.password_algo, u.password_key_id, u.password_hash
+		FROM reset_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE (t.token_key_id, t.token_hash) IN (SELECT * FROM UNNEST($1::varchar[], $2::bytea[]))
+		AND t.created_at >= NOW() - INTERVAL '1 day'
+		AND u.status <> 'deleted'
+		`, keyIDs, tokenHashes).Scan(&userID, &email, &name, &current.Algo, &current.KeyID, &current.Hash)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			w.WriteHeader(http.StatusNotFound)
@@ -577,8 +774,35 @@ func PasswordResetHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
+	// Check the password policy
+	if v := password.Check(p.Password, password.Inputs{Email: email, Name: name}); len(v) > 0 {
+		writePasswordViolations(w, r, v)
+		return
+	}
+
+	// Check the password history
+	reused, err := password.Reused(db, userID, p.Password, current)
+	if err != nil {
+		logs.Err(
+			db,
+			"Password history err",
+			"Failed to check the password history",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if reused {
+		writePasswordViolations(w, r, []password.Violation{password.ReusedViolation()})
+		return
+	}
+
 	// Hash the new password
-	hash, err := argon2id.CreateHash(p.Password, argon2id.DefaultParams)
+	hash, err := password.Hash(p.Password)
 	if err != nil {
 		logs.Err(
 			db,
@@ -594,16 +818,31 @@ func PasswordResetHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	// Update the user's password
-	_, err = db.Exec(`
-		UPDATE users
-		SET password_hash = $1
-		WHERE id = $2`, hash, userID)
+	// Update the user's password (a reset also clears a security lock) & keep the old one in the history together
+	tx, err := db.Begin()
+	if err == nil {
+		defer func() {
+			_ = tx.Rollback()
+		}()
+		_, err = tx.Exec(`
+			UPDATE users
+			SET password_hash = $1, password_algo = $2, password_key_id = $3,
+			    status = CASE WHEN status = 'locked' THEN 'active' ELSE status END,
+			    status_reason = CASE WHEN status = 'locked' THEN NULL ELSE status_reason END,
+			    status_changed_at = CASE WHEN status = 'locked' THEN NOW() ELSE status_changed_at END
+			WHERE id = $4`, hash.Hash, hash.Algo, hash.KeyID, userID)
+	}
+	if err == nil {
+		err = password.Remember(tx, userID, current)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		logs.Err(
 			db,
 			"DB err",
-			"Failed to update the user",
+			"Failed to update the password & the password history",
 			err,
 			map[string]any{
 				"route": r.URL.Path,
@@ -648,6 +887,11 @@ func PasswordResetHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
+	audit.Log(db, r, audit.PasswordReset, userID, userID, nil)
+	notifySecurityEvent(db, r, userID, audit.PasswordReset, func(q email2.Queuer, lockLink string) error {
+		return email2.SendPasswordChanged(q, userID, email, lockLink)
+	})
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -681,14 +925,19 @@ func PasswordChangeHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	}()
 
 	// Validate
-	if len(p.Password) < 8 || len(p.NewPassword) < 8 {
+	if p.Password == "" {
 		w.WriteHeader(http.StatusUnprocessableEntity)
 		return
 	}
 
-	// Get the user's current password
-	var currentHash string
-	err = db.QueryRow(`SELECT password_hash FROM users WHERE id = $1`, userID).Scan(&currentHash)
+	// Get the user's current password, email & name
+	var current password.Stored
+	var email, name string
+	err = db.QueryRow(`
+		SELECT password_algo, password_key_id, password_hash, email, name
+		FROM users
+		WHERE id = $1`, userID).
+		Scan(&current.Algo, &current.KeyID, &current.Hash, &email, &name)
 	if err != nil {
 		logs.Err(
 			db,
@@ -705,29 +954,46 @@ func PasswordChangeHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	}
 
 	// Check if the password matches
-	match, err := argon2id.ComparePasswordAndHash(p.Password, currentHash)
+	match, err := password.Verify(p.Password, current)
+	if err != nil {
+		writePasswordVerifyError(w, r, db, userID, current.KeyID, err)
+		return
+	}
+
+	if !match {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Check the password policy
+	if v := password.Check(p.NewPassword, password.Inputs{Email: email, Name: name}); len(v) > 0 {
+		writePasswordViolations(w, r, v)
+		return
+	}
+
+	// Check the password history
+	reused, err := password.Reused(db, userID, p.NewPassword, current)
 	if err != nil {
-		log.Println(err)
 		logs.Err(
 			db,
-			"Argon2id err",
-			"Argon2id failed to compare password",
+			"Password history err",
+			"Failed to check the password history",
 			err,
 			map[string]any{
 				"route": r.URL.Path,
 			},
 			userID,
 		)
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-
-	if !match {
-		w.WriteHeader(http.StatusUnauthorized)
+	if reused {
+		writePasswordViolations(w, r, []password.Violation{password.ReusedViolation()})
 		return
 	}
 
 	// Hash the new password
-	hash, err := argon2id.CreateHash(p.NewPassword, argon2id.DefaultParams)
+	hash, err := password.Hash(p.NewPassword)
 	if err != nil {
 		logs.Err(
 			db,
@@ -743,13 +1009,26 @@ func PasswordChangeHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	// Update the user
-	_, err = db.Exec(`UPDATE users SET password_hash = $1 WHERE id = $2`, hash, userID)
+	// Update the user & keep the old password in the history together
+	tx, err := db.Begin()
+	if err == nil {
+		defer func() {
+			_ = tx.Rollback()
+		}()
+		_, err = tx.Exec(`UPDATE users SET password_hash = $1, password_algo = $2, password_key_id = $3 WHERE id = $4`,
+			hash.Hash, hash.Algo, hash.KeyID, userID)
+	}
+	if err == nil {
+		err = password.Remember(tx, userID, current)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		logs.Err(
 			db,
 			"DB err",
-			"Failed to update the user",
+			"Failed to update the password & the password history",
 			err,
 			map[string]any{
 				"route": r.URL.Path,
@@ -777,6 +1056,11 @@ func PasswordChangeHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
+	audit.Log(db, r, audit.PasswordChanged, userID, userID, nil)
+	notifySecurityEvent(db, r, userID, audit.PasswordChanged, func(q email2.Queuer, lockLink string) error {
+		return email2.SendPasswordChanged(q, userID, email, lockLink)
+	})
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -789,18 +1073,25 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	}
 
 	// Hash the token
-	tokenHash := users.HashToken(token)
+	keyIDs, tokenHashes := users.TokenHashes(token)
 
 	// Delete from DB
-	_, err := db.Exec(`DELETE FROM sessions WHERE token_hash = $1`, tokenHash)
-	if err != nil {
+	var userID int64
+	err := db.QueryRow(`
+		DELETE FROM sessions
+		WHERE (token_key_id, token_hash) IN (SELECT * FROM UNNEST($1::varchar[], $2::bytea[]))
+		RETURNING user_id
+		`, keyIDs, tokenHashes).Scan(&userID)
+	if err == nil {
+		audit.Log(db, r, audit.Logout, userID, userID, nil)
+	} else if !errors.Is(err, sql.ErrNoRows) {
 		logs.Err(
 			db,
 			"DB err",
 			"Failed to query the DB.",
 			err,
 			map[string]any{
-				"token": tokenHash,
+				"token": users.HashToken(token),
 				"stage": "db_delete",
 			},
 			0,
@@ -809,3 +1100,69 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 
 	w.WriteHeader(http.StatusNoContent)
 }
+
+// writePasswordVerifyError - Responds to a failed password comparison. A hash peppered with a key that was
+// removed from PEPPER_KEYS can't be checked anymore, the user has to reset their password (401); anything else is a 500.
+func writePasswordVerifyError(w http.ResponseWriter, r *http.Request, db *sql.DB, userID int64, keyID string, err error) {
+	if errors.Is(err, pepper.ErrUnknownKey) {
+		logs.Err(
+			db,
+			"Pepper err",
+			"The password hash uses a retired pepper key",
+			err,
+			map[string]any{
+				"route":  r.URL.Path,
+				"key_id": keyID,
+			},
+			userID,
+		)
+		writeError(w, r, http.StatusUnauthorized, "password_reset_required")
+		return
+	}
+
+	logs.Err(
+		db,
+		"Argon2id err",
+		"Argon2id failed to compare password",
+		err,
+		map[string]any{
+			"route": r.URL.Path,
+		},
+		userID,
+	)
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+// writePasswordViolations - Responds with a 422 listing the password policy rules that failed (in the client's language)
+func writePasswordViolations(w http.ResponseWriter, r *http.Request, v []password.Violation) {
+	locale := i18n.FromRequest(r)
+	minLength, maxLength := password.Lengths()
+	for i := range v {
+		switch v[i].Rule {
+		case password.RuleMinLength:
+			v[i].Message = i18n.T(locale, "password."+v[i].Rule, minLength)
+		case password.RuleMaxLength:
+			v[i].Message = i18n.T(locale, "password."+v[i].Rule, maxLength)
+		default:
+			v[i].Message = i18n.T(locale, "password."+v[i].Rule)
+		}
+	}
+
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error":      "password_policy",
+		"message":    i18n.T(locale, "error.password_policy"),
+		"violations": v,
+	})
+}
+
+// writeEmailDomainViolation - Answers 422 with the email domain rule that was broken
+func writeEmailDomainViolation(w http.ResponseWriter, r *http.Request, rule string) {
+	locale := i18n.FromRequest(r)
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error":   "email_domain_policy",
+		"rule":    rule,
+		"message": i18n.T(locale, "email."+rule),
+	})
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"app/helpers/audit"
+	email2 "app/helpers/email"
+	"app/helpers/logs"
+	"app/helpers/password"
+	"app/helpers/users"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// DeleteAccountHandler - Deletes the user's account after password confirmation.
+// The account can be restored via the emailed link until the grace period ends, then it's purged.
+func DeleteAccountHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	// Get token
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Get user ID from token
+	userID, err := users.GetId(token, w, db)
+	if err != nil {
+		return
+	}
+
+	// Payload
+	type Payload struct {
+		Password string `json:"password"`
+	}
+	var p Payload
+
+	// Decode
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&p)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Validate
+	if p.Password == "" {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Get the user's email & password
+	var email string
+	var current password.Stored
+	err = db.QueryRow(`
+		SELECT email, password_algo, password_key_id, password_hash
+		FROM users
+		WHERE id = $1`, userID).
+		Scan(&email, &current.Algo, &current.KeyID, &current.Hash)
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to query the DB.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Check if the password matches
+	match, err := password.Verify(p.Password, current)
+	if err != nil {
+		writePasswordVerifyError(w, r, db, userID, current.KeyID, err)
+		return
+	}
+	if !match {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Generate a restore token & hash it
+	rawToken, err := gonanoid.New(128)
+	if err != nil {
+		logs.Err(
+			db,
+			"Token gen err",
+			"Gonanoid failed to generate a token.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	tokenHash := users.HashToken(rawToken)
+
+	// Store the token, mark the account as deleted (revoking all sessions) & queue the confirmation email together
+	frontend := os.Getenv("FRONTEND_URL")
+	u := fmt.Sprintf("%s/auth/restore?token=%s", frontend, url.PathEscape(rawToken))
+	tx, err := db.Begin()
+	if err == nil {
+		defer func() {
+			_ = tx.Rollback()
+		}()
+		_, err = tx.Exec(`
+			INSERT INTO account_restore_tokens (user_id, token_hash, token_key_id)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (user_id)
+			DO UPDATE SET token_hash = EXCLUDED.token_hash, token_key_id = EXCLUDED.token_key_id, created_at = NOW()
+			`, userID, tokenHash, users.TokenKeyID())
+	}
+	if err == nil {
+		err = users.SetStatusTx(tx, userID, users.StatusDeleted, "deleted by user")
+	}
+	if err == nil {
+		err = email2.SendAccountDeletion(tx, userID, email, u, users.DeletionGraceDays())
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to delete the account.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	audit.Log(db, r, audit.AccountDeleted, userID, userID, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RestoreAccountHandler - Cancels a pending account deletion using the emailed link
+func RestoreAccountHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	// Get token from URL & hash it
+	rawToken := chi.URLParam(r, "token")
+	keyIDs, tokenHashes := users.TokenHashes(rawToken)
+
+	// Delete the token & get the user ID
+	var userID int64
+	var createdAt time.Time
+	err := db.QueryRow(`
+		DELETE FROM account_restore_tokens
+		WHERE (token_key_id, token_hash) IN (SELECT * FROM UNNEST($1::varchar[], $2::bytea[]))
+		RETURNING user_id, created_at
+		`, keyIDs, tokenHashes).Scan(&userID, &createdAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			logs.Err(
+				db,
+				"DB err",
+				"Failed to query the DB.",
+				err,
+				map[string]any{
+					"route": r.URL.Path,
+				},
+				userID,
+			)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Check if the grace period is over
+	if time.Since(createdAt) > users.DeletionGracePeriod() {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+
+	// Only a self-deleted account can be restored this way
+	var status string
+	err = db.QueryRow(`SELECT status FROM users WHERE id = $1 AND purged_at IS NULL`, userID).Scan(&status)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to query the DB.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if status != users.StatusDeleted {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+
+	// Reactivate the account
+	err = users.SetStatus(db, userID, users.StatusActive, "")
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to restore the account.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	audit.Log(db, r, audit.AccountRestored, userID, userID, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
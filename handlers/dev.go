@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	email2 "app/helpers/email"
+	"app/helpers/i18n"
+	"encoding/json"
+	"html"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Development email preview (APP_ENVIRONMENT=dev only, see routes)
+
+var hrefPattern = regexp.MustCompile(`href="([^"]+)"`)
+
+// devEmail - A captured message as shown by the preview
+type devEmail struct {
+	ID      string            `json:"id"`
+	To      string            `json:"to"`
+	Subject string            `json:"subject"`
+	SentAt  time.Time         `json:"sent_at"`
+	Headers map[string]string `json:"headers"`
+	HTML    string            `json:"html"`
+	Text    string            `json:"text"`
+	Links   []string          `json:"links"` // Every link in the HTML, so flows can be followed without an inbox
+}
+
+func newDevEmail(m email2.Message, sentAt time.Time) devEmail {
+	e := devEmail{
+		ID:      m.ID,
+		To:      m.To,
+		Subject: m.Subject,
+		SentAt:  sentAt,
+		Headers: m.Headers,
+		HTML:    m.HTML,
+		Text:    m.Text,
+		Links:   []string{},
+	}
+	for _, match := range hrefPattern.FindAllStringSubmatch(m.HTML, -1) {
+		e.Links = append(e.Links, html.UnescapeString(match[1]))
+	}
+	return e
+}
+
+var devTemplates = template.Must(template.New("").Parse(`
+{{define "head"}}<!doctype html><html><head><meta charset="utf-8"><title>{{.}}</title>
+<style>
+body{font-family:system-ui,sans-serif;margin:24px;color:#222}
+table{border-collapse:collapse;width:100%}td,th{text-align:left;padding:6px 10px;border-bottom:1px solid #ddd}
+iframe{width:100%;height:600px;border:1px solid #ddd}pre{background:#f6f6f6;padding:12px;white-space:pre-wrap}
+.muted{color:#888}
+</style></head><body>{{end}}
+
+{{define "index"}}{{template "head" "Emails"}}
+<h1>Emails</h1>
+{{if .Emails}}<table>
+<tr><th>Sent</th><th>To</th><th>Subject</th></tr>
+{{range .Emails}}<tr><td>{{.SentAt.Format "15:04:05"}}</td><td>{{.To}}</td><td><a href="/dev/emails/{{.ID}}">{{.Subject}}</a></td></tr>
+{{end}}</table>
+<form method="post" action="/dev/emails/clear"><button>Clear</button></form>
+{{else}}<p class="muted">Nothing sent yet.</p>{{end}}
+<h2>Templates</h2>
+<table>
+{{range .Templates}}<tr><td>{{.}}</td><td>{{$name := .}}{{range $.Locales}}<a href="/dev/emails/templates/{{$name}}?locale={{.}}">{{.}}</a> {{end}}</td></tr>
+{{end}}</table>
+</body></html>{{end}}
+
+{{define "email"}}{{template "head" .Subject}}
+<p><a href="/dev/emails">&larr; Emails</a></p>
+<h1>{{.Subject}}</h1>
+<table>
+<tr><th>To</th><td>{{.To}}</td></tr>
+{{if not .SentAt.IsZero}}<tr><th>Sent</th><td>{{.SentAt.Format "2006-01-02 15:04:05"}}</td></tr>{{end}}
+{{range $k, $v := .Headers}}<tr><th>{{$k}}</th><td>{{$v}}</td></tr>{{end}}
+{{range .Links}}<tr><th>Link</th><td><a href="{{.}}" target="_blank">{{.}}</a></td></tr>{{end}}
+</table>
+<h2>HTML</h2>
+<iframe srcdoc="{{.HTML}}"></iframe>
+<h2>Text</h2>
+<pre>{{.Text}}</pre>
+</body></html>{{end}}
+`))
+
+// DevEmailsHandler - Lists the captured emails (JSON with ?format=json) & the templates that can be previewed
+func DevEmailsHandler(w http.ResponseWriter, r *http.Request) {
+	emails := []devEmail{}
+	if c := email2.Captured(); c != nil {
+		sent := c.Messages()
+		for i := len(sent) - 1; i >= 0; i-- { // Newest first
+			emails = append(emails, newDevEmail(sent[i].Message, sent[i].SentAt))
+		}
+	}
+
+	if r.URL.Query().Get("format") == "json" {
+		w.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"emails": emails,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_ = devTemplates.ExecuteTemplate(w, "index", map[string]any{
+		"Emails":    emails,
+		"Templates": email2.TemplateNames(),
+		"Locales":   i18n.Supported,
+	})
+}
+
+// DevEmailHandler - Shows one captured email: headers, links, HTML & text bodies
+func DevEmailHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := url.PathUnescape(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	c := email2.Captured()
+	if c == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	for _, m := range c.Messages() {
+		if m.ID == id {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			_ = devTemplates.ExecuteTemplate(w, "email", newDevEmail(m.Message, m.SentAt))
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// DevClearEmailsHandler - Forgets the captured emails
+func DevClearEmailsHandler(w http.ResponseWriter, r *http.Request) {
+	if c := email2.Captured(); c != nil {
+		c.Reset()
+	}
+	http.Redirect(w, r, "/dev/emails", http.StatusSeeOther)
+}
+
+// DevEmailTemplateHandler - Renders a template with sample data (?locale=de for a translation)
+func DevEmailTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	locale := i18n.Default
+	if v := r.URL.Query().Get("locale"); v != "" {
+		var ok bool
+		if locale, ok = i18n.Normalize(v); !ok {
+			writeError(w, r, http.StatusUnprocessableEntity, "unsupported_locale")
+			return
+		}
+	}
+
+	m, err := email2.Preview(locale, chi.URLParam(r, "name"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_ = devTemplates.ExecuteTemplate(w, "email", newDevEmail(*m, time.Time{}))
+}
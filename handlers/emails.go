@@ -0,0 +1,528 @@
+package handlers
+
+import (
+	"app/helpers/audit"
+	email2 "app/helpers/email"
+	"app/helpers/logs"
+	"app/helpers/users"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// maxEmails - How many addresses (primary included) one account can have
+const maxEmails = 10
+
+// userEmail - A row of user_emails as returned by the API
+type userEmail struct {
+	ID       string `json:"id"`
+	Email    string `json:"email"`
+	Verified bool   `json:"verified"`
+	Primary  bool   `json:"primary"`
+	// On the suppression list (bounced or complained)
+	Undeliverable bool      `json:"undeliverable"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// ListEmailsHandler - Lists the user's email addresses, primary first
+func ListEmailsHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	// Get token
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Get user ID from token
+	userID, err := users.GetId(token, w, db)
+	if err != nil {
+		return
+	}
+
+	// Get the addresses
+	emails, err := func() ([]userEmail, error) {
+		rows, err := db.Query(`
+			SELECT e.id, e.email, e.verified, e.is_primary, `+email2.UndeliverableSQL+`, e.created_at
+			FROM user_emails e
+			WHERE e.user_id = $1
+			ORDER BY e.is_primary DESC, e.created_at
+			`, userID)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			_ = rows.Close()
+		}()
+
+		emails := []userEmail{}
+		for rows.Next() {
+			var e userEmail
+			if err = rows.Scan(&e.ID, &e.Email, &e.Verified, &e.Primary, &e.Undeliverable, &e.CreatedAt); err != nil {
+				return nil, err
+			}
+			emails = append(emails, e)
+		}
+		return emails, rows.Err()
+	}()
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to query the DB.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Return the addresses
+	w.WriteHeader(http.StatusOK)
+	if err = json.NewEncoder(w).Encode(map[string]any{
+		"emails": emails,
+	}); err != nil {
+		logs.Err(
+			db,
+			"Return err",
+			"Failed to return the data.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			userID,
+		)
+	}
+}
+
+// AddEmailHandler - Adds an unverified address to the account & sends it a verification link
+func AddEmailHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	// Get token
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Get user ID from token
+	userID, err := users.GetId(token, w, db)
+	if err != nil {
+		return
+	}
+
+	// Payload
+	type Payload struct {
+		Email string `json:"email"`
+	}
+	var p Payload
+
+	// Decode
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&p)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Validate & format email
+	email := strings.TrimSpace(strings.ToLower(p.Email))
+	if len(email) > 254 {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+	_, err = mail.ParseAddress(email)
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+	if rule := users.CheckEmailDomain(r.Context(), email); rule != "" {
+		writeEmailDomainViolation(w, r, rule)
+		return
+	}
+
+	// Check the account's limit & if the address is taken (by this or another account)
+	var count int
+	var taken bool
+	err = db.QueryRow(`
+		SELECT
+		(SELECT COUNT(*) FROM user_emails WHERE user_id = $2),
+		EXISTS (
+			SELECT 1 FROM user_emails
+			WHERE (email = $1 OR canonical_email = $4) AND (user_id = $2 OR verified OR is_primary)
+		)
+		OR
+		EXISTS (
+			SELECT 1 FROM email_change_reverts
+			WHERE (old_email = $1 OR old_canonical_email = $4)
+			  AND created_at >= NOW() - make_interval(days => $3)
+			  AND user_id <> $2
+		)
+		`, email, userID, users.EmailRevertDays(), users.CanonicalEmail(email)).Scan(&count, &taken)
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to query the DB.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+				"email": email,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if taken {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if count >= maxEmails {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Generate a verification token & hash it
+	rawToken, err := gonanoid.New(128)
+	if err != nil {
+		logs.Err(
+			db,
+			"Token gen err",
+			"Gonanoid failed to generate a token.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Store the address & its token & queue the verification email together
+	frontend := os.Getenv("FRONTEND_URL")
+	u := fmt.Sprintf("%s/profile/emails/verify?token=%s", frontend, url.PathEscape(rawToken))
+	e := userEmail{Email: email}
+	tx, err := db.Begin()
+	if err == nil {
+		defer func() {
+			_ = tx.Rollback()
+		}()
+		err = tx.QueryRow(`
+			WITH e AS (
+				INSERT INTO user_emails (user_id, email, canonical_email)
+				VALUES ($1, $2, $5)
+				RETURNING id, created_at
+			), t AS (
+				INSERT INTO user_email_tokens (email_id, token_hash, token_key_id)
+				SELECT id, $3, $4 FROM e
+			)
+			SELECT id, created_at FROM e
+			`, userID, email, users.HashToken(rawToken), users.TokenKeyID(), users.CanonicalEmail(email)).Scan(&e.ID, &e.CreatedAt)
+	}
+	if err == nil {
+		err = email2.SendVerification(tx, userID, email, u)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to store the email.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+				"email": email,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	audit.Log(db, r, audit.EmailAdded, userID, userID, map[string]any{"email": email})
+
+	// Return the address
+	w.WriteHeader(http.StatusCreated)
+	if err = json.NewEncoder(w).Encode(map[string]any{
+		"email": e,
+	}); err != nil {
+		logs.Err(
+			db,
+			"Return err",
+			"Failed to return the data.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			userID,
+		)
+	}
+}
+
+// VerifyEmailHandler - Verifies an added address using the emailed link
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	// Get token from URL & hash it
+	rawToken := chi.URLParam(r, "token")
+	keyIDs, tokenHashes := users.TokenHashes(rawToken)
+
+	// Delete the token & get the address
+	var emailID string
+	var createdAt time.Time
+	err := db.QueryRow(`
+		DELETE FROM user_email_tokens
+		WHERE (token_key_id, token_hash) IN (SELECT * FROM UNNEST($1::varchar[], $2::bytea[]))
+		RETURNING email_id, created_at
+		`, keyIDs, tokenHashes).Scan(&emailID, &createdAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			logs.Err(
+				db,
+				"DB err",
+				"Failed to query the DB.",
+				err,
+				map[string]any{
+					"route": r.URL.Path,
+				},
+				0,
+			)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Check if the token is expired
+	if time.Since(createdAt) > 24*time.Hour {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+
+	// Verify the address, unless another account verified it first
+	// (under the address lock, so a concurrent registration or verification of the same mailbox can't slip in)
+	var userID int64
+	var email string
+	tx, err := db.Begin()
+	if err == nil {
+		defer func() {
+			_ = tx.Rollback()
+		}()
+		err = tx.QueryRow(`SELECT email FROM user_emails WHERE id = $1`, emailID).Scan(&email)
+	}
+	if err == nil {
+		err = users.LockEmail(tx, email)
+	}
+	if err == nil {
+		err = tx.QueryRow(`
+			UPDATE user_emails e
+			SET verified = TRUE, verified_at = NOW()
+			WHERE e.id = $1
+			  AND NOT EXISTS (
+				SELECT 1 FROM user_emails o
+				WHERE (o.email = e.email OR o.canonical_email = e.canonical_email) AND o.id <> e.id AND (o.verified OR o.is_primary)
+			  )
+			RETURNING e.user_id, e.email
+			`, emailID).Scan(&userID, &email)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to verify the email.",
+			err,
+			map[string]any{
+				"route":    r.URL.Path,
+				"email_id": emailID,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	audit.Log(db, r, audit.EmailAddressVerified, userID, userID, map[string]any{"email": email})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// MakePrimaryEmailHandler - Makes a verified address the primary one (used for notifications & shown on the profile)
+func MakePrimaryEmailHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	// Get token
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Get user ID from token
+	userID, err := users.GetId(token, w, db)
+	if err != nil {
+		return
+	}
+
+	// Swap the primary address
+	emailID := chi.URLParam(r, "id")
+	var oldEmail, newEmail string
+	var verified, primary bool
+	err = func() error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = tx.Rollback()
+		}()
+
+		err = tx.QueryRow(`
+			SELECT email, verified, is_primary
+			FROM user_emails
+			WHERE id::text = $1 AND user_id = $2
+			FOR UPDATE
+			`, emailID, userID).Scan(&newEmail, &verified, &primary)
+		if err != nil || !verified || primary {
+			return err
+		}
+
+		err = tx.QueryRow(`
+			UPDATE user_emails
+			SET is_primary = FALSE
+			WHERE user_id = $1 AND is_primary
+			RETURNING email
+			`, userID).Scan(&oldEmail)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE user_emails SET is_primary = TRUE WHERE id::text = $1`, emailID)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			UPDATE users
+			SET email = $1, email_verified = TRUE
+			WHERE id = $2
+			`, newEmail, userID)
+		if err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	}()
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to change the primary email.",
+			err,
+			map[string]any{
+				"route":    r.URL.Path,
+				"email_id": emailID,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !verified {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if primary {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	audit.Log(db, r, audit.PrimaryEmailChanged, userID, userID, map[string]any{
+		"old_email": oldEmail,
+		"new_email": newEmail,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveEmailHandler - Removes an extra address from the account (the primary one can't be removed)
+func RemoveEmailHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	// Get token
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Get user ID from token
+	userID, err := users.GetId(token, w, db)
+	if err != nil {
+		return
+	}
+
+	// Delete the address (the SELECT still sees the row as it was before the DELETE)
+	emailID := chi.URLParam(r, "id")
+	var email string
+	var verified, primary bool
+	err = db.QueryRow(`
+		WITH d AS (
+			DELETE FROM user_emails
+			WHERE id::text = $1 AND user_id = $2 AND NOT is_primary
+			RETURNING id
+		)
+		SELECT email, verified, is_primary
+		FROM user_emails
+		WHERE id::text = $1 AND user_id = $2
+		`, emailID, userID).Scan(&email, &verified, &primary)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to remove the email.",
+			err,
+			map[string]any{
+				"route":    r.URL.Path,
+				"email_id": emailID,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if primary {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	audit.Log(db, r, audit.EmailRemoved, userID, userID, map[string]any{"email": email})
+
+	// Tell the removed address (if it was ever confirmed)
+	if verified {
+		notifySecurityEvent(db, r, userID, audit.EmailRemoved, func(q email2.Queuer, lockLink string) error {
+			return email2.SendEmailRemoved(q, userID, email, lockLink)
+		})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
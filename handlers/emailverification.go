@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"app/helpers/audit"
 	email2 "app/helpers/email"
 	"app/helpers/logs"
 	"app/helpers/users"
@@ -22,12 +23,15 @@ import (
 func EmailVerificationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	// Get token & hash it
 	rawToken := chi.URLParam(r, "token")
-	token := users.HashToken(rawToken)
+	keyIDs, tokens := users.TokenHashes(rawToken)
 
 	// Get user ID from token & delete the token
 	var userID int64
-	err := db.QueryRow(`DELETE FROM verification_tokens WHERE token_hash = $1 RETURNING user_id`, token).
-		Scan(&userID)
+	err := db.QueryRow(`
+		DELETE FROM verification_tokens
+		WHERE (token_key_id, token_hash) IN (SELECT * FROM UNNEST($1::varchar[], $2::bytea[]))
+		RETURNING user_id
+		`, keyIDs, tokens).Scan(&userID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			w.WriteHeader(http.StatusNotFound)
@@ -47,8 +51,18 @@ func EmailVerificationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB
 		return
 	}
 
-	// Update the user's email_verified
-	_, err = db.Exec(`UPDATE users SET email_verified = TRUE WHERE id = $1`, userID)
+	// Update the user's email_verified (& their primary address)
+	_, err = db.Exec(`
+		WITH e AS (
+			UPDATE user_emails
+			SET verified = TRUE, verified_at = NOW()
+			WHERE user_id = $1 AND is_primary AND NOT verified
+		)
+		UPDATE users
+		SET email_verified = TRUE,
+		    status = CASE WHEN status = 'unverified' THEN 'active' ELSE status END,
+		    status_changed_at = CASE WHEN status = 'unverified' THEN NOW() ELSE status_changed_at END
+		WHERE id = $1`, userID)
 	if err != nil {
 		logs.Err(
 			db,
@@ -63,6 +77,7 @@ func EmailVerificationHandler(w http.ResponseWriter, r *http.Request, db *sql.DB
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	audit.Log(db, r, audit.EmailVerified, userID, userID, nil)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -97,7 +112,8 @@ func ResendEmailVerificationHandler(w http.ResponseWriter, r *http.Request, db *
 	// Check the user's email_verified
 	var userID int64
 	var verified bool
-	err = db.QueryRow(`SELECT id, email_verified FROM users WHERE email = $1`, email).Scan(&userID, &verified)
+	err = db.QueryRow(`SELECT id, email_verified FROM users WHERE email = $1 AND status <> 'deleted'`, email).
+		Scan(&userID, &verified)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			w.WriteHeader(http.StatusNoContent) // Fake OK
@@ -123,6 +139,28 @@ func ResendEmailVerificationHandler(w http.ResponseWriter, r *http.Request, db *
 		return
 	}
 
+	// Don't keep mailing an address that bounced or complained
+	suppressed, err := email2.IsSuppressed(db, email)
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to check the suppression list",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+				"email": email,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if suppressed {
+		w.WriteHeader(http.StatusNoContent) // Fake OK, the profile shows the address is undeliverable
+		return
+	}
+
 	// Check existing token timestamp
 	var lastSent time.Time
 	err = db.QueryRow(`
@@ -171,18 +209,32 @@ func ResendEmailVerificationHandler(w http.ResponseWriter, r *http.Request, db *
 	}
 	tokenHash := users.HashToken(rawToken)
 
-	// Store the verification token
-	_, err = db.Exec(`
-		INSERT INTO verification_tokens(user_id, token_hash)
-		VALUES ($1, $2)
-		ON CONFLICT (user_id)
-		DO UPDATE SET token_hash = EXCLUDED.token_hash, created_at = NOW()
-	`, userID, tokenHash)
+	// Store the verification token & queue the email together
+	frontend := os.Getenv("FRONTEND_URL")
+	u := fmt.Sprintf("%s/auth/verify?token=%s", frontend, url.PathEscape(rawToken))
+	tx, err := db.Begin()
+	if err == nil {
+		defer func() {
+			_ = tx.Rollback()
+		}()
+		_, err = tx.Exec(`
+			INSERT INTO verification_tokens(user_id, token_hash, token_key_id)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (user_id)
+			DO UPDATE SET token_hash = EXCLUDED.token_hash, token_key_id = EXCLUDED.token_key_id, created_at = NOW()
+		`, userID, tokenHash, users.TokenKeyID())
+	}
+	if err == nil {
+		err = email2.SendVerification(tx, userID, email, u)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		logs.Err(
 			db,
 			"DB err",
-			"Failed to query the DB",
+			"Failed to store the token & queue the verification email",
 			err,
 			map[string]any{
 				"route":   r.URL.Path,
@@ -194,27 +246,7 @@ func ResendEmailVerificationHandler(w http.ResponseWriter, r *http.Request, db *
 		return
 	}
 
-	// Send the verification email
-	go func() {
-		frontend := os.Getenv("FRONTEND_URL")
-		u := fmt.Sprintf("%s/auth/verify?token=%s", frontend, url.PathEscape(rawToken))
-		err := email2.SendVerification(email, u)
-		if err != nil {
-			logs.Err(
-				db,
-				"SMTP err",
-				"Failed to send mail",
-				err,
-				map[string]any{
-					"route": r.URL.Path,
-					"email": email,
-					"url":   u,
-				},
-				userID,
-			)
-			return
-		}
-	}()
+	audit.Log(db, r, audit.VerificationResent, 0, userID, nil)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,16 @@
+package handlers
+
+import (
+	"app/helpers/i18n"
+	"encoding/json"
+	"net/http"
+)
+
+// writeError - Responds with an error code & its message in the client's language (Accept-Language)
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error":   code,
+		"message": i18n.T(i18n.FromRequest(r), "error."+code),
+	})
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"app/helpers/audit"
+	"app/helpers/logs"
+	"app/helpers/users"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// RequestDataExportHandler - Queues an export of everything we hold about the user.
+// The archive is built in the background & its download link is emailed.
+func RequestDataExportHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	// Get token
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Get user ID from token
+	userID, err := users.GetId(token, w, db)
+	if err != nil {
+		return
+	}
+
+	// Queue the export (one per day, prevents spam).
+	// Exports that failed or whose download link couldn't be emailed don't count, the user can ask again.
+	res, err := db.Exec(`
+		INSERT INTO data_exports (user_id)
+		SELECT $1
+		WHERE NOT EXISTS (
+			SELECT 1 FROM data_exports e
+			WHERE e.user_id = $1
+			  AND (
+				e.status = 'pending'
+				OR (
+					e.created_at >= NOW() - INTERVAL '1 day'
+					AND e.status <> 'failed'
+					AND NOT EXISTS (
+						SELECT 1 FROM email_outbox o
+						WHERE o.user_id = $1
+						  AND o.template = 'data-export.html'
+						  AND o.status IN ('dead', 'skipped')
+						  AND o.created_at >= e.completed_at
+					)
+				)
+			  )
+		)`, userID)
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to queue the data export.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	audit.Log(db, r, audit.DataExportRequested, userID, userID, nil)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// DownloadDataExportHandler - Returns a finished data export archive using the emailed link
+func DownloadDataExportHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	// Get token from URL & hash it
+	rawToken := chi.URLParam(r, "token")
+	keyIDs, tokenHashes := users.TokenHashes(rawToken)
+
+	// Get the archive & the account's status
+	var userID int64
+	var archive []byte
+	var expired bool
+	var status string
+	err := db.QueryRow(`
+		SELECT x.user_id, COALESCE(x.archive, 'null'::jsonb), x.status <> 'ready' OR x.expires_at < NOW(), u.status
+		FROM data_exports x
+		JOIN users u ON u.id = x.user_id
+		WHERE (x.token_key_id, x.token_hash) IN (SELECT * FROM UNNEST($1::varchar[], $2::bytea[]))
+		`, keyIDs, tokenHashes).Scan(&userID, &archive, &expired, &status)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			logs.Err(
+				db,
+				"DB err",
+				"Failed to query the DB.",
+				err,
+				map[string]any{
+					"route": r.URL.Path,
+				},
+				userID,
+			)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	if expired {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+
+	// Only accounts that could still log in get their archive (the link may predate a suspension or deletion)
+	if status != users.StatusActive {
+		writeError(w, r, http.StatusForbidden, "account_"+status)
+		return
+	}
+
+	audit.Log(db, r, audit.DataExportDownloaded, 0, userID, nil)
+
+	// Return the archive as a file
+	w.Header().Set("Content-Disposition", `attachment; filename="data-export.json"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(archive); err != nil {
+		logs.Err(
+			db,
+			"Return err",
+			"Failed to return the data export.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			userID,
+		)
+	}
+}
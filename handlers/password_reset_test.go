@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"app/helpers/email"
+	"app/helpers/users"
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// ===== Scripted database =====
+
+// fakeDB - A database/sql connector answering queries from a script & recording every statement,
+// enough to run a handler without Postgres
+type fakeDB struct {
+	mu    sync.Mutex
+	execs []fakeExec
+	// rows - Result of a query, matched by a substring of the SQL (no match returns no rows)
+	rows map[string][][]driver.Value
+}
+
+type fakeExec struct {
+	query string
+	args  []any
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return nil }
+
+// exec - The first recorded statement containing s
+func (f *fakeDB) exec(s string) (fakeExec, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, e := range f.execs {
+		if strings.Contains(e.query, s) {
+			return e, true
+		}
+	}
+	return fakeExec{}, false
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (c fakeConn) Close() error                        { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)           { return fakeTx{}, nil }
+
+func (c fakeConn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+func (c fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e := fakeExec{query: query}
+	for _, a := range args {
+		e.args = append(e.args, a.Value)
+	}
+	c.db.mu.Lock()
+	c.db.execs = append(c.db.execs, e)
+	c.db.mu.Unlock()
+	return driver.RowsAffected(1), nil
+}
+
+func (c fakeConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	for match, rows := range c.db.rows {
+		if strings.Contains(query, match) {
+			return &fakeRows{rows: rows}, nil
+		}
+	}
+	return &fakeRows{}, nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	return make([]string, len(r.rows[0]))
+}
+func (r *fakeRows) Close() error { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// ===== Tests =====
+
+// Requesting a reset queues the email in the token's transaction, once delivered it carries a link
+// whose token is the one stored for the user
+func TestSendPasswordResetEmailsTheStoredToken(t *testing.T) {
+	t.Setenv("APPLICATION_NAME", "Acme")
+	t.Setenv("SMTP_FROM", "no-reply@example.com")
+	t.Setenv("FRONTEND_URL", "https://app.example.com")
+	t.Setenv("EMAIL_TEMPLATES_DIR", "")
+	if err := email.LoadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	mem := email.NewMemoryMailer()
+	email.Use(mem)
+	t.Cleanup(func() { email.Use(nil) })
+
+	f := &fakeDB{rows: map[string][][]driver.Value{
+		"FROM user_emails e": {{int64(42), "Jane@Example.com"}},
+	}}
+	db := sql.OpenDB(f)
+
+	body := bytes.NewBufferString(`{"email": "jane@example.com"}`)
+	rec := httptest.NewRecorder()
+	SendPasswordResetHandler(rec, httptest.NewRequest(http.MethodPost, "/v1/auth/reset-password", body), db)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status %d, want 204", rec.Code)
+	}
+	if e, ok := f.exec("INSERT INTO errors"); ok {
+		t.Fatalf("handler logged an error: %v", e.args)
+	}
+
+	// What the outbox job would send
+	token, ok := f.exec("INSERT INTO reset_tokens")
+	if !ok {
+		t.Fatal("no reset token stored")
+	}
+	queued, ok := f.exec("INSERT INTO email_outbox")
+	if !ok {
+		t.Fatal("no email queued")
+	}
+	to, template := queued.args[1].(string), queued.args[2].(string)
+	var data map[string]any
+	if err := json.Unmarshal([]byte(queued.args[3].(string)), &data); err != nil {
+		t.Fatal(err)
+	}
+	if err := email.Deliver(context.Background(), to, "en", template, data); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := mem.Messages()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sent))
+	}
+	m := sent[0].Message
+	if m.To != "Jane@Example.com" {
+		t.Errorf("To = %q, want the address as stored", m.To)
+	}
+	if m.Subject == "" || !strings.HasPrefix(m.From, "Acme <") {
+		t.Errorf("Subject %q, From %q", m.Subject, m.From)
+	}
+
+	link := regexp.MustCompile(`https://app\.example\.com/auth/reset\?token=([A-Za-z0-9_-]+)`).FindStringSubmatch(m.Text)
+	if link == nil {
+		t.Fatalf("no reset link in the text part:\n%s", m.Text)
+	}
+	if !strings.Contains(m.HTML, link[0]) {
+		t.Error("the HTML part links elsewhere")
+	}
+	if !bytes.Equal(users.HashToken(link[1]), token.args[1].([]byte)) {
+		t.Error("the emailed token isn't the one stored")
+	}
+}
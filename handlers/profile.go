@@ -1,9 +1,12 @@
 package handlers
 
 import (
+	"app/helpers/audit"
 	email2 "app/helpers/email"
+	"app/helpers/i18n"
 	"app/helpers/logs"
 	"app/helpers/users"
+	"app/utils"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -35,14 +38,26 @@ func ProfileHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 
 	// User struct
 	type User struct {
-		ID    string `json:"id"`
-		Name  string `json:"name"`
-		Email string `json:"email"`
+		ID       string  `json:"id"`
+		Name     string  `json:"name"`
+		Username *string `json:"username"`
+		Email    string  `json:"email"`
+		// The primary address bounced or complained, ask for a new one
+		EmailUndeliverable bool    `json:"email_undeliverable"`
+		Phone              *string `json:"phone"`
+		TwoFA              bool    `json:"sms_2fa_enabled"`
+		Locale             string  `json:"locale"`
 	}
 	var u User
 
 	// Get user data
-	err = db.QueryRow(`SELECT id, name, email FROM users WHERE id = $1`, userID).Scan(&u.ID, &u.Name, &u.Email)
+	err = db.QueryRow(`
+		SELECT u.id, u.name, u.username, u.email,
+		       COALESCE((SELECT `+email2.UndeliverableSQL+` FROM user_emails e WHERE e.user_id = u.id AND e.is_primary), FALSE),
+		       u.phone, u.sms_2fa_enabled, u.locale
+		FROM users u
+		WHERE u.id = $1`, userID).
+		Scan(&u.ID, &u.Name, &u.Username, &u.Email, &u.EmailUndeliverable, &u.Phone, &u.TwoFA, &u.Locale)
 	if err != nil {
 		logs.Err(
 			db,
@@ -87,9 +102,11 @@ func UpdateProfileHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	// Payload
+	// Payload (omitted fields stay unchanged, an empty username removes it)
 	type Payload struct {
-		Name string `json:"name"`
+		Name     *string `json:"name"`
+		Username *string `json:"username"`
+		Locale   *string `json:"locale"`
 	}
 	var p Payload
 
@@ -103,13 +120,64 @@ func UpdateProfileHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	}
 
 	// Validate
-	if len(p.Name) > 64 {
+	if p.Name != nil && len(*p.Name) > 64 {
 		w.WriteHeader(http.StatusUnprocessableEntity)
 		return
 	}
+	if p.Username != nil {
+		*p.Username = strings.TrimSpace(*p.Username)
+		if *p.Username != "" {
+			if rule := users.CheckUsername(*p.Username); rule != "" {
+				writeUsernameViolation(w, r, rule)
+				return
+			}
+		}
+	}
+	if p.Locale != nil {
+		locale, ok := i18n.Normalize(*p.Locale)
+		if !ok {
+			writeError(w, r, http.StatusUnprocessableEntity, "unsupported_locale")
+			return
+		}
+		p.Locale = &locale
+	}
+
+	// Check if the username is taken
+	if p.Username != nil && *p.Username != "" {
+		var exists bool
+		err = db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE LOWER(username) = LOWER($1) AND id <> $2)`, *p.Username, userID).
+			Scan(&exists)
+		if err != nil {
+			logs.Err(
+				db,
+				"DB err",
+				"Failed to query the DB.",
+				err,
+				map[string]any{
+					"payload": p,
+				},
+				userID,
+			)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if exists {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+	}
 
 	// Update the user
-	_, err = db.Exec(`UPDATE users SET name = $1 WHERE id = $2`, p.Name, userID)
+	_, err = db.Exec(`
+		UPDATE users
+		SET name = COALESCE($1, name),
+		    username = CASE WHEN $2::text IS NULL THEN username ELSE NULLIF($2, '') END,
+		    locale = COALESCE($3, locale)
+		WHERE id = $4`, p.Name, p.Username, p.Locale, userID)
+	if utils.IsUniqueViolation(err, "users_username_idx") {
+		w.WriteHeader(http.StatusConflict) // Taken between the check & the update
+		return
+	}
 	if err != nil {
 		logs.Err(
 			db,
@@ -124,10 +192,87 @@ func UpdateProfileHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	var fields []string
+	if p.Name != nil {
+		fields = append(fields, "name")
+	}
+	if p.Username != nil {
+		fields = append(fields, "username")
+	}
+	if p.Locale != nil {
+		fields = append(fields, "locale")
+	}
+	audit.Log(db, r, audit.ProfileUpdated, userID, userID, map[string]any{
+		"fields": fields,
+	})
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// UsernameAvailableHandler - Tells if a username can be taken (rate limited, it reveals existing usernames)
+func UsernameAvailableHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	username := strings.TrimSpace(r.URL.Query().Get("username"))
+
+	// Check the policy
+	resp := map[string]any{
+		"available": false,
+	}
+	if rule := users.CheckUsername(username); rule != "" {
+		resp["reason"] = rule
+	} else {
+		// Check if it's taken
+		var exists bool
+		err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE LOWER(username) = LOWER($1))`, username).
+			Scan(&exists)
+		if err != nil {
+			logs.Err(
+				db,
+				"DB err",
+				"Failed to query the DB.",
+				err,
+				map[string]any{
+					"route":    r.URL.Path,
+					"username": username,
+				},
+				0,
+			)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if exists {
+			resp["reason"] = "taken"
+		} else {
+			resp["available"] = true
+		}
+	}
+
+	// Return the result
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logs.Err(
+			db,
+			"Return err",
+			"Failed to return the data.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			0,
+		)
+	}
+}
+
+// writeUsernameViolation - Answers 422 with the username rule that was broken
+func writeUsernameViolation(w http.ResponseWriter, r *http.Request, rule string) {
+	locale := i18n.FromRequest(r)
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error":   "username_policy",
+		"rule":    rule,
+		"message": i18n.T(locale, "username."+rule),
+	})
+}
+
 // RequestEmailChangeHandler - Creates & sends an email change link when the user requests it
 func RequestEmailChangeHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	// Get token
@@ -169,6 +314,10 @@ func RequestEmailChangeHandler(w http.ResponseWriter, r *http.Request, db *sql.D
 		w.WriteHeader(http.StatusUnprocessableEntity)
 		return
 	}
+	if rule := users.CheckEmailDomain(r.Context(), email); rule != "" {
+		writeEmailDomainViolation(w, r, rule)
+		return
+	}
 
 	// Get email from ID
 	var userEmail string
@@ -199,8 +348,8 @@ func RequestEmailChangeHandler(w http.ResponseWriter, r *http.Request, db *sql.D
 	err = db.QueryRow(`
 		SELECT
 		EXISTS (
-		  SELECT 1 FROM users
-		  WHERE LOWER(email) = LOWER($1) AND id <> $2
+		  SELECT 1 FROM user_emails
+		  WHERE (email = LOWER($1) OR canonical_email = $4) AND (verified OR is_primary) AND user_id <> $2
 		)
 		OR
 		EXISTS (
@@ -209,7 +358,14 @@ func RequestEmailChangeHandler(w http.ResponseWriter, r *http.Request, db *sql.D
 			  AND (created_at + INTERVAL '1 day' >= NOW())
 			  AND user_id <> $2
 		)
-		`, email, userID).Scan(&exists)
+		OR
+		EXISTS (
+			SELECT 1 FROM email_change_reverts
+			WHERE (old_email = LOWER($1) OR old_canonical_email = $4)
+			  AND created_at >= NOW() - make_interval(days => $3)
+			  AND user_id <> $2
+		)
+		`, email, userID, users.EmailRevertDays(), users.CanonicalEmail(email)).Scan(&exists)
 	if err != nil {
 		logs.Err(
 			db,
@@ -250,16 +406,30 @@ func RequestEmailChangeHandler(w http.ResponseWriter, r *http.Request, db *sql.D
 	}
 	tokenHash := users.HashToken(rawToken)
 
-	// Store the token
-	// Store/refresh the token (replace existing row for this user)
-	_, err = db.Exec(`
-		INSERT INTO email_change_tokens (user_id, token_hash, new_email)
-		VALUES ($1, $2, $3)
-		ON CONFLICT (user_id) DO UPDATE
-		SET token_hash = EXCLUDED.token_hash,
-			new_email  = EXCLUDED.new_email,
-			created_at = NOW()
-	`, userID, tokenHash, email)
+	// Store/refresh the token (replace existing row for this user) & queue the email together
+	frontend := os.Getenv("FRONTEND_URL")
+	u := fmt.Sprintf("%s/auth/change-email?token=%s", frontend, url.PathEscape(rawToken))
+	tx, err := db.Begin()
+	if err == nil {
+		defer func() {
+			_ = tx.Rollback()
+		}()
+		_, err = tx.Exec(`
+			INSERT INTO email_change_tokens (user_id, token_hash, token_key_id, new_email)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (user_id) DO UPDATE
+			SET token_hash   = EXCLUDED.token_hash,
+				token_key_id = EXCLUDED.token_key_id,
+				new_email    = EXCLUDED.new_email,
+				created_at   = NOW()
+		`, userID, tokenHash, users.TokenKeyID(), email)
+	}
+	if err == nil {
+		err = email2.SendEmailChange(tx, userID, email, u)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		logs.Err(
 			db,
@@ -275,26 +445,9 @@ func RequestEmailChangeHandler(w http.ResponseWriter, r *http.Request, db *sql.D
 		return
 	}
 
-	// Send the email
-	go func() {
-		frontend := os.Getenv("FRONTEND_URL")
-		u := fmt.Sprintf("%s/auth/change-email?token=%s", frontend, url.PathEscape(rawToken))
-		err = email2.SendEmailChange(email, u)
-		if err != nil {
-			logs.Err(
-				db,
-				"SMTP err",
-				"Failed to send mail",
-				err,
-				map[string]any{
-					"route": r.URL.Path,
-					"email": email,
-				},
-				userID,
-			)
-			return
-		}
-	}()
+	audit.Log(db, r, audit.EmailChangeRequested, userID, userID, map[string]any{
+		"new_email": email,
+	})
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -303,6 +456,7 @@ func UpdateEmail(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	// Get token from URL param & hash it
 	rawChangeToken := chi.URLParam(r, "token")
 	changeTokenHash := users.HashToken(rawChangeToken)
+	keyIDs, changeTokenHashes := users.TokenHashes(rawChangeToken)
 
 	// Delete the email change token & get its new email
 	var newEmail string
@@ -310,9 +464,9 @@ func UpdateEmail(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	var userID int64
 	err := db.QueryRow(`
 		DELETE FROM email_change_tokens
-		WHERE token_hash = $1
+		WHERE (token_key_id, token_hash) IN (SELECT * FROM UNNEST($1::varchar[], $2::bytea[]))
 		RETURNING new_email, created_at, user_id
-		`, changeTokenHash).Scan(&newEmail, &tokenCreatedAt, &userID)
+		`, keyIDs, changeTokenHashes).Scan(&newEmail, &tokenCreatedAt, &userID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			w.WriteHeader(http.StatusNotFound)
@@ -339,9 +493,83 @@ func UpdateEmail(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	// Check if the email is already taken
-	var exists bool
-	err = db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE email = $1)`, newEmail).Scan(&exists)
+	// Generate a revert token for the old address & hash it
+	rawRevertToken, err := gonanoid.New(128)
+	if err != nil {
+		logs.Err(
+			db,
+			"Token gen err",
+			"Gonanoid failed to generate a token.",
+			err,
+			map[string]any{
+				"change_token_hash": changeTokenHash,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Update the user's email & keep the old one for the recovery window
+	var oldEmail string
+	var taken bool
+	err = func() error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = tx.Rollback()
+		}()
+
+		// Check if the email is already taken (or reserved for another user's revert),
+		// under the address lock so a concurrent registration or change can't claim it in between
+		if err = users.LockEmail(tx, newEmail); err != nil {
+			return err
+		}
+		err = tx.QueryRow(`
+			SELECT
+			EXISTS (
+				SELECT 1 FROM user_emails
+				WHERE (email = $1 OR canonical_email = $4) AND (verified OR is_primary) AND user_id <> $2
+			)
+			OR
+			EXISTS (
+				SELECT 1 FROM email_change_reverts
+				WHERE (old_email = $1 OR old_canonical_email = $4)
+				  AND created_at >= NOW() - make_interval(days => $3)
+				  AND user_id <> $2
+			)
+			`, newEmail, userID, users.EmailRevertDays(), users.CanonicalEmail(newEmail)).Scan(&taken)
+		if err != nil || taken {
+			return err
+		}
+
+		err = tx.QueryRow(`SELECT email FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&oldEmail)
+		if err != nil {
+			return err
+		}
+		if err = users.ReplacePrimaryEmail(tx, userID, newEmail); err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO email_change_reverts (user_id, old_email, old_canonical_email, new_email, token_hash, token_key_id)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			`, userID, oldEmail, users.CanonicalEmail(oldEmail), newEmail, users.HashToken(rawRevertToken), users.TokenKeyID())
+		if err != nil {
+			return err
+		}
+
+		// Queue the revert link to the old address
+		frontend := os.Getenv("FRONTEND_URL")
+		u := fmt.Sprintf("%s/auth/revert-email?token=%s", frontend, url.PathEscape(rawRevertToken))
+		if err = email2.SendEmailChanged(tx, userID, oldEmail, newEmail, u, users.EmailRevertDays()); err != nil {
+			return err
+		}
+
+		return tx.Commit()
+	}()
 	if err != nil {
 		logs.Err(
 			db,
@@ -357,22 +585,93 @@ func UpdateEmail(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	if exists {
+	if taken {
 		w.WriteHeader(http.StatusConflict)
 		return
 	}
+	audit.Log(db, r, audit.EmailChanged, userID, userID, map[string]any{
+		"old_email": oldEmail,
+		"new_email": newEmail,
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevertEmailHandler - Restores the previous email using the link sent to it.
+// Revokes all sessions & locks the account until the password is reset, in case the change was an account takeover.
+func RevertEmailHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	// Get token from URL & hash it
+	rawToken := chi.URLParam(r, "token")
+	keyIDs, tokenHashes := users.TokenHashes(rawToken)
+
+	// Delete the revert token & get the old email
+	var userID int64
+	var oldEmail, newEmail string
+	var createdAt time.Time
+	err := db.QueryRow(`
+		DELETE FROM email_change_reverts
+		WHERE (token_key_id, token_hash) IN (SELECT * FROM UNNEST($1::varchar[], $2::bytea[]))
+		RETURNING user_id, old_email, new_email, created_at
+		`, keyIDs, tokenHashes).Scan(&userID, &oldEmail, &newEmail, &createdAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			logs.Err(
+				db,
+				"DB err",
+				"Failed to query the DB.",
+				err,
+				map[string]any{
+					"route": r.URL.Path,
+				},
+				userID,
+			)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Check if the recovery window is over
+	if time.Since(createdAt) > users.EmailRevertPeriod() {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+
+	// Restore the old email (verified, it just received the link)
+	var status string
+	err = func() error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = tx.Rollback()
+		}()
+
+		err = tx.QueryRow(`SELECT status FROM users WHERE id = $1 AND purged_at IS NULL FOR UPDATE`, userID).Scan(&status)
+		if err != nil {
+			return err
+		}
+		if err = users.ReplacePrimaryEmail(tx, userID, oldEmail); err != nil {
+			return err
+		}
 
-	// Update the user's email
-	_, err = db.Exec(`UPDATE users SET email = $1 WHERE id = $2`, newEmail, userID)
+		return tx.Commit()
+	}()
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
 		logs.Err(
 			db,
 			"DB err",
-			"Failed to query the DB.",
+			"Failed to restore the email.",
 			err,
 			map[string]any{
-				"change_token_hash": changeTokenHash,
-				"new_email":         newEmail,
+				"route":     r.URL.Path,
+				"old_email": oldEmail,
 			},
 			userID,
 		)
@@ -380,5 +679,48 @@ func UpdateEmail(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
+	// Drop later reverts & pending tokens (a reset link may have gone to the new address)
+	for _, q := range []string{
+		`DELETE FROM email_change_reverts WHERE user_id = $1`,
+		`DELETE FROM email_change_tokens WHERE user_id = $1`,
+		`DELETE FROM reset_tokens WHERE user_id = $1`,
+	} {
+		if _, err = db.Exec(q, userID); err != nil {
+			logs.Err(
+				db,
+				"DB err",
+				"Failed to clear pending tokens.",
+				err,
+				map[string]any{
+					"route": r.URL.Path,
+				},
+				userID,
+			)
+		}
+	}
+
+	// Lock the account & revoke all sessions, a password reset unlocks it
+	if status != users.StatusSuspended && status != users.StatusDeleted {
+		err = users.SetStatus(db, userID, users.StatusLocked, "email change reverted")
+		if err != nil {
+			logs.Err(
+				db,
+				"DB err",
+				"Failed to lock the account.",
+				err,
+				map[string]any{
+					"route": r.URL.Path,
+				},
+				userID,
+			)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	audit.Log(db, r, audit.EmailChangeReverted, 0, userID, map[string]any{
+		"old_email": oldEmail,
+		"new_email": newEmail,
+	})
+
 	w.WriteHeader(http.StatusNoContent)
 }
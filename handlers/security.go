@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"app/helpers/audit"
+	email2 "app/helpers/email"
+	"app/helpers/logs"
+	"app/helpers/password"
+	"app/helpers/users"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// lockLinkTTL - How long a "this wasn't me" link stays valid
+const lockLinkTTL = 7 * 24 * time.Hour
+
+// notifySecurityEvent - Creates a "this wasn't me" link for the event & queues the notice built by send with it.
+// Failures are logged, they never fail the request.
+func notifySecurityEvent(db *sql.DB, r *http.Request, userID int64, event string, send func(q email2.Queuer, lockLink string) error) {
+	route := r.URL.Path
+	fail := func(name string, message string, err error) {
+		logs.Err(
+			db,
+			name,
+			message,
+			err,
+			map[string]any{
+				"route": route,
+				"event": event,
+			},
+			userID,
+		)
+	}
+
+	// Generate a lock token & hash it
+	rawToken, err := gonanoid.New(128)
+	if err != nil {
+		fail("Token gen err", "Gonanoid failed to generate a token.", err)
+		return
+	}
+
+	// The token & the notice are stored together
+	tx, err := db.Begin()
+	if err != nil {
+		fail("DB err", "Failed to begin the transaction.", err)
+		return
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// Store the token
+	_, err = tx.Exec(`
+		INSERT INTO security_lock_tokens (user_id, token_hash, token_key_id, event_type)
+		VALUES ($1, $2, $3, $4)
+		`, userID, users.HashToken(rawToken), users.TokenKeyID(), event)
+	if err != nil {
+		fail("DB err", "Failed to store the lock token.", err)
+		return
+	}
+
+	// Queue the notice
+	frontend := os.Getenv("FRONTEND_URL")
+	u := fmt.Sprintf("%s/auth/lock?token=%s", frontend, url.PathEscape(rawToken))
+	if err = send(tx, u); err != nil {
+		fail("Email queue error", "Failed to queue the security notice", err)
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		fail("DB err", "Failed to commit the transaction.", err)
+	}
+}
+
+// isNewDevice - Checks if the user has logged in before, but never from this user agent in the last 90 days
+func isNewDevice(db *sql.DB, userID int64, userAgent string) (bool, error) {
+	var loggedIn, known bool
+	err := db.QueryRow(`
+		SELECT
+			EXISTS (
+				SELECT 1 FROM audit_events
+				WHERE subject_id = $1 AND event_type = $2
+			),
+			EXISTS (
+				SELECT 1 FROM audit_events
+				WHERE subject_id = $1 AND event_type = $2
+				  AND user_agent IS NOT DISTINCT FROM NULLIF($3, '')
+				  AND created_at >= NOW() - INTERVAL '90 days'
+			)
+		`, userID, audit.LoginSucceeded, userAgent).Scan(&loggedIn, &known)
+	if err != nil {
+		return false, err
+	}
+	return loggedIn && !known, nil
+}
+
+// LockAccountHandler - Locks the account & revokes all sessions using a "this wasn't me" link.
+// The user regains access by resetting their password.
+func LockAccountHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	// Get token from URL & hash it
+	rawToken := chi.URLParam(r, "token")
+	keyIDs, tokenHashes := users.TokenHashes(rawToken)
+
+	// Delete the token & get the user ID
+	var userID int64
+	var event string
+	var createdAt time.Time
+	err := db.QueryRow(`
+		DELETE FROM security_lock_tokens
+		WHERE (token_key_id, token_hash) IN (SELECT * FROM UNNEST($1::varchar[], $2::bytea[]))
+		RETURNING user_id, event_type, created_at
+		`, keyIDs, tokenHashes).Scan(&userID, &event, &createdAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			logs.Err(
+				db,
+				"DB err",
+				"Failed to query the DB.",
+				err,
+				map[string]any{
+					"route": r.URL.Path,
+				},
+				userID,
+			)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	// Check if the token is expired
+	if time.Since(createdAt) > lockLinkTTL {
+		w.WriteHeader(http.StatusGone)
+		return
+	}
+
+	// Suspended & deleted accounts are already unusable, don't override their status
+	var status string
+	err = db.QueryRow(`SELECT status FROM users WHERE id = $1`, userID).Scan(&status)
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to query the DB.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if status == users.StatusSuspended || status == users.StatusDeleted {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Lock the account & revoke all sessions
+	err = users.SetStatus(db, userID, users.StatusLocked, "reported by user: "+event)
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to lock the account.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+				"event": event,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Drop pending tokens an attacker may have requested
+	for _, q := range []string{
+		`DELETE FROM security_lock_tokens WHERE user_id = $1`,
+		`DELETE FROM reset_tokens WHERE user_id = $1`,
+		`DELETE FROM email_change_tokens WHERE user_id = $1`,
+	} {
+		if _, err = db.Exec(q, userID); err != nil {
+			logs.Err(
+				db,
+				"DB err",
+				"Failed to clear pending tokens.",
+				err,
+				map[string]any{
+					"route": r.URL.Path,
+				},
+				userID,
+			)
+		}
+	}
+	audit.Log(db, r, audit.AccountLocked, 0, userID, map[string]any{"event": event})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ReauthenticateHandler - Confirms the password (or a texted code) on the current session.
+// Sensitive routes (behind mw.RequireRecentAuth) are allowed for the re-authentication window after this.
+func ReauthenticateHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	// Get token
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Get user ID from token
+	userID, err := users.GetId(token, w, db)
+	if err != nil {
+		return
+	}
+
+	// Payload
+	type Payload struct {
+		Password  string `json:"password"`
+		Challenge string `json:"challenge"`
+		Code      string `json:"code"`
+	}
+	var p Payload
+
+	// Decode
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&p)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Validate
+	if p.Password == "" && (p.Challenge == "" || p.Code == "") {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	if p.Password == "" {
+		// Check the texted code (see ReauthSMSHandler)
+		c, err := users.CheckChallenge(db, p.Challenge, strings.TrimSpace(p.Code), users.ChallengeReauth)
+		if err == nil && c.UserID != userID {
+			err = users.ErrChallengeNotFound
+		}
+		if err != nil {
+			if errors.Is(err, users.ErrWrongCode) || errors.Is(err, users.ErrChallengeExpired) {
+				audit.Log(db, r, audit.ReauthFailed, userID, userID, map[string]any{"method": "sms"})
+			}
+			writeChallengeError(w, r, db, err)
+			return
+		}
+	} else {
+		// Get the user's password
+		var current password.Stored
+		err = db.QueryRow(`
+			SELECT password_algo, password_key_id, password_hash
+			FROM users
+			WHERE id = $1`, userID).
+			Scan(&current.Algo, &current.KeyID, &current.Hash)
+		if err != nil {
+			logs.Err(
+				db,
+				"DB err",
+				"Failed to query the DB.",
+				err,
+				map[string]any{
+					"route": r.URL.Path,
+				},
+				userID,
+			)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// Check if the password matches
+		match, err := password.Verify(p.Password, current)
+		if err != nil {
+			writePasswordVerifyError(w, r, db, userID, current.KeyID, err)
+			return
+		}
+		if !match {
+			audit.Log(db, r, audit.ReauthFailed, userID, userID, nil)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// Stamp the session
+	err = users.MarkReauthenticated(db, token)
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to stamp the session.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	audit.Log(db, r, audit.Reauthenticated, userID, userID, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
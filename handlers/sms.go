@@ -0,0 +1,617 @@
+package handlers
+
+import (
+	"app/helpers/audit"
+	email2 "app/helpers/email"
+	"app/helpers/i18n"
+	"app/helpers/logs"
+	"app/helpers/sms"
+	"app/helpers/users"
+	"app/mw"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+	"github.com/sony/sonyflake"
+)
+
+// smsResendDelay - Minimum time between two codes of the same kind for a user
+const smsResendDelay = time.Minute
+
+// smsPhoneLimit - Codes texted to the same number per hour, whoever asks for them
+// (SMS_SENDS_PER_PHONE_PER_HOUR, default 5), on top of the per-IP & per-user limits
+var smsPhoneLimit = sync.OnceValue(func() *mw.Limiter {
+	n := 5
+	if v, err := strconv.Atoi(os.Getenv("SMS_SENDS_PER_PHONE_PER_HOUR")); err == nil && v > 0 {
+		n = v
+	}
+	return mw.NewLimiter(n, time.Hour)
+})
+
+// smsAllowed - Whether a code may be texted now: the user's last one of that kind is older than smsResendDelay
+// & the number is under smsPhoneLimit
+func smsAllowed(db *sql.DB, userID int64, kind, phone string) (bool, error) {
+	last, err := users.LastChallengeAt(db, userID, kind)
+	if err != nil {
+		return false, err
+	}
+	if time.Since(last) < smsResendDelay {
+		return false, nil
+	}
+	allowed, _ := smsPhoneLimit().Allow(phone)
+	return allowed, nil
+}
+
+// startSMSChallenge - Texts a code to the phone & answers 202 with the challenge token the code must be sent with
+func startSMSChallenge(w http.ResponseWriter, r *http.Request, db *sql.DB, userID int64, kind, phone string) {
+	token, code, err := users.NewChallenge(db, userID, kind, phone)
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to store the SMS challenge.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+				"kind":  kind,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Send the code in the user's language
+	var locale string
+	if err = db.QueryRow(`SELECT locale FROM users WHERE id = $1`, userID).Scan(&locale); err != nil {
+		locale = i18n.Default
+	}
+	route := r.URL.Path
+	go func() {
+		body := i18n.T(locale, "sms.code", code, os.Getenv("APPLICATION_NAME"))
+		if err := sms.Send(phone, body); err != nil {
+			logs.Err(
+				db,
+				"SMS err",
+				"Failed to send the SMS",
+				err,
+				map[string]any{
+					"route": route,
+					"kind":  kind,
+				},
+				userID,
+			)
+		}
+	}()
+
+	writeChallenge(w, db, token, phone, userID)
+}
+
+// writeFakeChallenge - Answers like startSMSChallenge without texting anything (the token matches no challenge)
+func writeFakeChallenge(w http.ResponseWriter, db *sql.DB, phone string) {
+	token, err := gonanoid.New(64)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeChallenge(w, db, token, phone, 0)
+}
+
+// maskPhone - Hides all but the first & last 2 characters of a phone number ("+4*******89")
+func maskPhone(phone string) string {
+	if len(phone) <= 4 {
+		return phone
+	}
+	return phone[:2] + strings.Repeat("*", len(phone)-4) + phone[len(phone)-2:]
+}
+
+// writeChallenge - Answers 202 with the challenge token & the masked phone it was sent to
+func writeChallenge(w http.ResponseWriter, db *sql.DB, token, phone string, userID int64) {
+	w.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"challenge": token,
+		"method":    "sms",
+		"phone":     maskPhone(phone),
+	}); err != nil {
+		logs.Err(
+			db,
+			"Return err",
+			"Failed to return the challenge.",
+			err,
+			nil,
+			userID,
+		)
+	}
+}
+
+// writeChallengeError - Answers a failed code check
+func writeChallengeError(w http.ResponseWriter, r *http.Request, db *sql.DB, err error) {
+	switch {
+	case errors.Is(err, users.ErrChallengeNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	case errors.Is(err, users.ErrChallengeExpired):
+		w.WriteHeader(http.StatusGone)
+	case errors.Is(err, users.ErrWrongCode):
+		writeError(w, r, http.StatusUnauthorized, "wrong_code")
+	default:
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to check the SMS challenge.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			0,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// LoginChallengeHandler - Finishes a 2FA or SMS login with the texted code & returns a session token
+func LoginChallengeHandler(w http.ResponseWriter, r *http.Request, sf *sonyflake.Sonyflake, db *sql.DB) {
+	// Payload
+	type Payload struct {
+		Challenge string `json:"challenge"`
+		Code      string `json:"code"`
+	}
+	var p Payload
+
+	// Decode
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&p)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Validate
+	if p.Challenge == "" || p.Code == "" {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Check the code
+	c, err := users.CheckChallenge(db, p.Challenge, strings.TrimSpace(p.Code), users.ChallengeTwoFA, users.ChallengeSMSLogin)
+	if err != nil {
+		if errors.Is(err, users.ErrWrongCode) || errors.Is(err, users.ErrChallengeExpired) {
+			audit.Log(db, r, audit.LoginFailed, 0, c.UserID, map[string]any{"reason": "wrong_code", "method": c.Kind})
+		}
+		writeChallengeError(w, r, db, err)
+		return
+	}
+
+	// The account may have been locked since the code was sent
+	var email, status string
+	err = db.QueryRow(`SELECT email, status FROM users WHERE id = $1`, c.UserID).Scan(&email, &status)
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to query the DB.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			c.UserID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if status != users.StatusActive {
+		audit.Log(db, r, audit.LoginFailed, 0, c.UserID, map[string]any{"reason": "account_" + status})
+		writeError(w, r, http.StatusForbidden, "account_"+status)
+		return
+	}
+
+	method := "2fa"
+	if c.Kind == users.ChallengeSMSLogin {
+		method = "sms"
+	}
+	startSession(w, r, sf, db, c.UserID, email, method)
+}
+
+// SMSLoginHandler - Texts a login code to a verified phone number (when SMS_LOGIN_ENABLED=true)
+func SMSLoginHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if os.Getenv("SMS_LOGIN_ENABLED") != "true" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Payload
+	type Payload struct {
+		Phone string `json:"phone"`
+	}
+	var p Payload
+
+	// Decode
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&p)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Format phone
+	phone, err := sms.NormalizePhone(p.Phone)
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Get the user owning the number
+	var userID int64
+	err = db.QueryRow(`SELECT id FROM users WHERE phone = $1 AND status = 'active'`, phone).Scan(&userID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			logs.Err(
+				db,
+				"DB err",
+				"Failed to query the DB.",
+				err,
+				map[string]any{
+					"route": r.URL.Path,
+				},
+				0,
+			)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		// Fake challenge, don't reveal which numbers are registered
+		audit.Log(db, r, audit.LoginFailed, 0, 0, map[string]any{"reason": "unknown_phone", "method": "sms"})
+		writeFakeChallenge(w, db, phone)
+		return
+	}
+
+	// Don't send codes more than once a minute (nor too many to the number). A throttled request gets
+	// the same fake challenge as an unknown number, a 429 would tell which numbers are registered.
+	allowed, err := smsAllowed(db, userID, users.ChallengeSMSLogin, phone)
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to query the DB.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		writeFakeChallenge(w, db, phone)
+		return
+	}
+
+	startSMSChallenge(w, r, db, userID, users.ChallengeSMSLogin, phone)
+}
+
+// SetPhoneHandler - Texts a verification code to a new phone number, it's saved once verified
+func SetPhoneHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	// Get token
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Get user ID from token
+	userID, err := users.GetId(token, w, db)
+	if err != nil {
+		return
+	}
+
+	// Payload
+	type Payload struct {
+		Phone string `json:"phone"`
+	}
+	var p Payload
+
+	// Decode
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&p)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Format phone
+	phone, err := sms.NormalizePhone(p.Phone)
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	// Check if the number is taken & if a code may be sent
+	var taken, allowed bool
+	err = db.QueryRow(`SELECT EXISTS (SELECT 1 FROM users WHERE phone = $1)`, phone).Scan(&taken)
+	if err == nil && !taken {
+		allowed, err = smsAllowed(db, userID, users.ChallengePhone, phone)
+	}
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to query the DB.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if taken {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if !allowed {
+		w.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+	audit.Log(db, r, audit.PhoneChangeRequested, userID, userID, map[string]any{"phone": phone})
+
+	startSMSChallenge(w, r, db, userID, users.ChallengePhone, phone)
+}
+
+// VerifyPhoneHandler - Saves the new phone number once its texted code is confirmed
+func VerifyPhoneHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	// Get token
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Get user ID from token
+	userID, err := users.GetId(token, w, db)
+	if err != nil {
+		return
+	}
+
+	// Payload
+	type Payload struct {
+		Challenge string `json:"challenge"`
+		Code      string `json:"code"`
+	}
+	var p Payload
+
+	// Decode
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&p)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Check the code
+	c, err := users.CheckChallenge(db, p.Challenge, strings.TrimSpace(p.Code), users.ChallengePhone)
+	if err == nil && c.UserID != userID {
+		err = users.ErrChallengeNotFound
+	}
+	if err != nil {
+		writeChallengeError(w, r, db, err)
+		return
+	}
+
+	// Save the number, unless someone verified it first
+	var email string
+	err = db.QueryRow(`
+		UPDATE users
+		SET phone = $1
+		WHERE id = $2 AND NOT EXISTS (SELECT 1 FROM users WHERE phone = $1)
+		RETURNING email
+		`, c.Phone, userID).Scan(&email)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to save the phone number.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	audit.Log(db, r, audit.PhoneVerified, userID, userID, map[string]any{"phone": c.Phone})
+	notifySecurityEvent(db, r, userID, audit.PhoneVerified, func(q email2.Queuer, lockLink string) error {
+		return email2.SendPhoneChanged(q, userID, email, maskPhone(c.Phone), lockLink)
+	})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemovePhoneHandler - Removes the phone number (which also turns SMS 2FA off)
+func RemovePhoneHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	// Get token
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Get user ID from token
+	userID, err := users.GetId(token, w, db)
+	if err != nil {
+		return
+	}
+
+	// Remove the number
+	var email string
+	var hadTwoFA bool
+	err = db.QueryRow(`
+		UPDATE users u
+		SET phone = NULL, sms_2fa_enabled = FALSE
+		FROM users old
+		WHERE u.id = $1 AND old.id = u.id
+		RETURNING u.email, old.sms_2fa_enabled
+		`, userID).Scan(&email, &hadTwoFA)
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to remove the phone number.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	audit.Log(db, r, audit.PhoneRemoved, userID, userID, nil)
+
+	if hadTwoFA {
+		audit.Log(db, r, audit.TwoFactorDisabled, userID, userID, nil)
+		notifySecurityEvent(db, r, userID, audit.TwoFactorDisabled, func(q email2.Queuer, lockLink string) error {
+			return email2.SendTwoFactorChanged(q, userID, email, false, lockLink)
+		})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// UpdateTwoFactorHandler - Turns SMS 2FA on (needs a verified phone number) or off
+func UpdateTwoFactorHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	// Get token
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Get user ID from token
+	userID, err := users.GetId(token, w, db)
+	if err != nil {
+		return
+	}
+
+	// Payload
+	type Payload struct {
+		Enabled bool `json:"enabled"`
+	}
+	var p Payload
+
+	// Decode
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&p)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Update the setting (only with a phone number)
+	var email string
+	var changed bool
+	err = db.QueryRow(`
+		UPDATE users u
+		SET sms_2fa_enabled = $1
+		FROM users old
+		WHERE u.id = $2 AND old.id = u.id AND (u.phone IS NOT NULL OR NOT $1)
+		RETURNING u.email, old.sms_2fa_enabled <> $1
+		`, p.Enabled, userID).Scan(&email, &changed)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			writeError(w, r, http.StatusConflict, "phone_required")
+			return
+		}
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to update the 2FA setting.",
+			err,
+			map[string]any{
+				"route":   r.URL.Path,
+				"payload": p,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if changed {
+		event := audit.TwoFactorDisabled
+		if p.Enabled {
+			event = audit.TwoFactorEnabled
+		}
+		audit.Log(db, r, event, userID, userID, nil)
+		notifySecurityEvent(db, r, userID, event, func(q email2.Queuer, lockLink string) error {
+			return email2.SendTwoFactorChanged(q, userID, email, p.Enabled, lockLink)
+		})
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ReauthSMSHandler - Texts a re-authentication code to the user's phone (see ReauthenticateHandler)
+func ReauthSMSHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	// Get token
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// Get user ID from token
+	userID, err := users.GetId(token, w, db)
+	if err != nil {
+		return
+	}
+
+	// Get the phone number
+	var phone sql.NullString
+	err = db.QueryRow(`SELECT phone FROM users WHERE id = $1`, userID).Scan(&phone)
+	if err == nil && phone.Valid {
+		var allowed bool
+		allowed, err = smsAllowed(db, userID, users.ChallengeReauth, phone.String)
+		if err == nil && !allowed {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+	}
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to query the DB.",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+			},
+			userID,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !phone.Valid {
+		writeError(w, r, http.StatusConflict, "phone_required")
+		return
+	}
+
+	startSMSChallenge(w, r, db, userID, users.ChallengeReauth, phone.String)
+}
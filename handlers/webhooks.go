@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"app/helpers/audit"
+	email2 "app/helpers/email"
+	"app/helpers/logs"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// webhookAuthorized - Checks the shared secret (EMAIL_WEBHOOK_SECRET) sent as a bearer token.
+// Without a secret the webhook is disabled.
+func webhookAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	secret := os.Getenv("EMAIL_WEBHOOK_SECRET")
+	if secret == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return false
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// SESWebhookHandler - Receives SES bounce & complaint notifications through an SNS subscription.
+// Messages must be signed by SNS for one of the SES_SNS_TOPIC_ARNS topics (the webhook is disabled without any).
+// Permanent bounces & complaints put the address on the suppression list.
+func SESWebhookHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	topics := email2.SNSTopics()
+	if len(topics) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// SNS envelope (SNS sends it as text/plain)
+	var env email2.SNSMessage
+	if err := json.NewDecoder(io.LimitReader(r.Body, 256<<10)).Decode(&env); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		_ = r.Body.Close()
+	}()
+
+	if err := email2.VerifySNS(r.Context(), &env, topics); err != nil {
+		logs.Err(
+			db,
+			"Webhook err",
+			"Rejected an SNS message",
+			err,
+			map[string]any{
+				"route":      r.URL.Path,
+				"topic_arn":  env.TopicArn,
+				"message_id": env.MessageID,
+			},
+			0,
+		)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch env.Type {
+	case "SubscriptionConfirmation":
+		if err := email2.ConfirmSNSSubscription(r.Context(), &env); err != nil {
+			logs.Err(
+				db,
+				"Webhook err",
+				"Failed to confirm the SNS subscription",
+				err,
+				map[string]any{
+					"route":     r.URL.Path,
+					"topic_arn": env.TopicArn,
+				},
+				0,
+			)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case "Notification":
+	default:
+		w.WriteHeader(http.StatusNoContent) // UnsubscribeConfirmation etc.
+		return
+	}
+
+	// SES notification ("notificationType" for identity notifications, "eventType" for configuration set events)
+	type Recipient struct {
+		EmailAddress   string `json:"emailAddress"`
+		DiagnosticCode string `json:"diagnosticCode"`
+	}
+	type Notification struct {
+		NotificationType string `json:"notificationType"`
+		EventType        string `json:"eventType"`
+		Bounce           struct {
+			BounceType        string      `json:"bounceType"`
+			BounceSubType     string      `json:"bounceSubType"`
+			BouncedRecipients []Recipient `json:"bouncedRecipients"`
+		} `json:"bounce"`
+		Complaint struct {
+			ComplaintFeedbackType string      `json:"complaintFeedbackType"`
+			ComplainedRecipients  []Recipient `json:"complainedRecipients"`
+		} `json:"complaint"`
+	}
+	var n Notification
+	if err := json.Unmarshal([]byte(env.Message), &n); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	kind := n.NotificationType
+	if kind == "" {
+		kind = n.EventType
+	}
+	switch kind {
+	case "Bounce":
+		// Transient bounces (full mailbox...) are retried by SES
+		if n.Bounce.BounceType != "Permanent" {
+			break
+		}
+		for _, rcpt := range n.Bounce.BouncedRecipients {
+			detail := rcpt.DiagnosticCode
+			if detail == "" {
+				detail = n.Bounce.BounceSubType
+			}
+			if !suppressAddress(w, r, db, rcpt.EmailAddress, email2.ReasonBounce, detail) {
+				return
+			}
+		}
+	case "Complaint":
+		for _, rcpt := range n.Complaint.ComplainedRecipients {
+			if !suppressAddress(w, r, db, rcpt.EmailAddress, email2.ReasonComplaint, n.Complaint.ComplaintFeedbackType) {
+				return
+			}
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EmailWebhookHandler - Receives a bounce or complaint in a provider independent format:
+// {"type": "bounce" | "complaint", "email": "...", "reason": "..."}. Only send hard bounces.
+func EmailWebhookHandler(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+	if !webhookAuthorized(w, r) {
+		return
+	}
+
+	// Payload
+	type Payload struct {
+		Type   string `json:"type"`
+		Email  string `json:"email"`
+		Reason string `json:"reason"`
+	}
+	var p Payload
+
+	// Decode
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer func() {
+		_ = r.Body.Close()
+	}()
+
+	if (p.Type != email2.ReasonBounce && p.Type != email2.ReasonComplaint) || strings.TrimSpace(p.Email) == "" {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		return
+	}
+
+	if !suppressAddress(w, r, db, p.Email, p.Type, p.Reason) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// suppressAddress - Suppresses the address & records it on the accounts using it, writes a 500 on failure
+func suppressAddress(w http.ResponseWriter, r *http.Request, db *sql.DB, address string, reason string, detail string) bool {
+	userIDs, err := email2.Suppress(db, address, reason, detail)
+	if err != nil {
+		logs.Err(
+			db,
+			"DB err",
+			"Failed to suppress the address",
+			err,
+			map[string]any{
+				"route":  r.URL.Path,
+				"email":  address,
+				"reason": reason,
+			},
+			0,
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+
+	for _, userID := range userIDs {
+		audit.Log(db, r, audit.EmailUndeliverable, 0, userID, map[string]any{"reason": reason})
+	}
+	return true
+}
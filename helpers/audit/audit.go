@@ -0,0 +1,94 @@
+package audit
+
+import (
+	"app/helpers/logs"
+	"database/sql"
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Event types (audit_events.event_type)
+const (
+	Registered             = "user.registered"
+	EmailVerified          = "user.email_verified"
+	VerificationResent     = "user.verification_resent"
+	ProfileUpdated         = "user.profile_updated"
+	EmailChangeRequested   = "user.email_change_requested"
+	EmailChanged           = "user.email_changed"
+	EmailChangeReverted    = "user.email_change_reverted"
+	EmailAdded             = "user.email_added"
+	EmailAddressVerified   = "user.email_address_verified"
+	PrimaryEmailChanged    = "user.primary_email_changed"
+	EmailRemoved           = "user.email_removed"
+	PhoneChangeRequested   = "user.phone_change_requested"
+	PhoneVerified          = "user.phone_verified"
+	PhoneRemoved           = "user.phone_removed"
+	AccountDeleted         = "user.account_deleted"
+	AccountRestored        = "user.account_restored"
+	StatusChanged          = "user.status_changed"
+	AccountLocked          = "user.account_locked"
+	DataExportRequested    = "user.data_export_requested"
+	DataExportDownloaded   = "user.data_export_downloaded"
+	LoginSucceeded         = "auth.login"
+	LoginFailed            = "auth.login_failed"
+	Logout                 = "auth.logout"
+	Reauthenticated        = "auth.reauthenticated"
+	ReauthFailed           = "auth.reauth_failed"
+	TwoFactorEnabled       = "auth.2fa_enabled"
+	TwoFactorDisabled      = "auth.2fa_disabled"
+	PasswordChanged        = "auth.password_changed"
+	PasswordResetRequested = "auth.password_reset_requested"
+	PasswordReset          = "auth.password_reset"
+	PasswordHashUpgraded   = "auth.password_hash_upgraded"
+	EmailUndeliverable     = "user.email_undeliverable"
+)
+
+// Log - Records an account event. actorID is who did it, subjectID whose account it concerns (0 if unknown).
+// Failures are logged to the errors table, they never fail the request.
+func Log(db *sql.DB, r *http.Request, event string, actorID, subjectID int64, metadata map[string]any) {
+	meta := []byte("{}")
+	if metadata != nil {
+		if b, err := json.Marshal(metadata); err == nil {
+			meta = b
+		}
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO audit_events
+			(actor_id, subject_id, event_type, ip, user_agent, request_id, metadata)
+		VALUES
+			(NULLIF($1::bigint, 0), NULLIF($2::bigint, 0), $3, NULLIF($4, ''), NULLIF($5, ''), NULLIF($6, ''), $7::jsonb)
+	`,
+		actorID,
+		subjectID,
+		event,
+		ClientIP(r),
+		r.UserAgent(),
+		middleware.GetReqID(r.Context()),
+		string(meta),
+	)
+	if err != nil {
+		logs.Err(
+			db,
+			"Audit log",
+			"Failed to store the audit event",
+			err,
+			map[string]any{
+				"route": r.URL.Path,
+				"event": event,
+			},
+			subjectID,
+		)
+	}
+}
+
+// ClientIP - The client's IP (RemoteAddr is already rewritten by mw.RealIP for trusted proxies)
+func ClientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
@@ -0,0 +1,213 @@
+package email
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dkimHeaders - Headers covered by the signature, when the message has them
+var dkimHeaders = []string{
+	"From", "Reply-To", "To", "Subject", "Date", "Message-ID",
+	"MIME-Version", "Content-Type", "List-Unsubscribe", "List-Unsubscribe-Post", "Auto-Submitted",
+}
+
+// DKIMSigner - Signs messages for Domain with the key published at Selector._domainkey.Domain
+// (relaxed/relaxed canonicalization, rsa-sha256 or ed25519-sha256 depending on the key)
+type DKIMSigner struct {
+	Domain   string
+	Selector string
+	key      crypto.Signer
+}
+
+var dkim *DKIMSigner
+
+func NewDKIMSigner(domain string, selector string, keyPEM []byte) (*DKIMSigner, error) {
+	if domain == "" || selector == "" {
+		return nil, errors.New("email: DKIM needs a domain & a selector")
+	}
+	key, err := parseDKIMKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return &DKIMSigner{Domain: domain, Selector: selector, key: key}, nil
+}
+
+// LoadDKIM - Enables signing when DKIM_DOMAIN is set. The key is DKIM_PRIVATE_KEY (PEM) or read from DKIM_PRIVATE_KEY_PATH.
+func LoadDKIM() error {
+	domain := os.Getenv("DKIM_DOMAIN")
+	if domain == "" {
+		dkim = nil
+		return nil
+	}
+
+	keyPEM := []byte(os.Getenv("DKIM_PRIVATE_KEY"))
+	if path := os.Getenv("DKIM_PRIVATE_KEY_PATH"); len(keyPEM) == 0 && path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("email: failed to read the DKIM key: %w", err)
+		}
+		keyPEM = b
+	}
+	s, err := NewDKIMSigner(domain, os.Getenv("DKIM_SELECTOR"), keyPEM)
+	if err != nil {
+		return err
+	}
+	dkim = s
+	return nil
+}
+
+// parseDKIMKey - An RSA (PKCS#1 or PKCS#8) or Ed25519 (PKCS#8) private key
+func parseDKIMKey(keyPEM []byte) (crypto.Signer, error) {
+	// Env files often hold the key on one line with literal \n
+	keyPEM = bytes.ReplaceAll(keyPEM, []byte(`\n`), []byte("\n"))
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("email: the DKIM private key is not PEM")
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("email: invalid DKIM private key: %w", err)
+	}
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("email: unsupported DKIM key type %T", key)
+	}
+}
+
+func (s *DKIMSigner) algorithm() string {
+	if _, ok := s.key.(ed25519.PrivateKey); ok {
+		return "ed25519-sha256"
+	}
+	return "rsa-sha256"
+}
+
+// Sign - The message with a DKIM-Signature header prepended
+func (s *DKIMSigner) Sign(raw []byte) ([]byte, error) {
+	head, body, ok := bytes.Cut(raw, []byte("\r\n\r\n"))
+	if !ok {
+		return nil, errors.New("email: message has no body")
+	}
+	fields := parseHeaderFields(string(head))
+
+	// Sign the last instance of each header (verifiers pick them bottom up)
+	var names []string
+	var signed strings.Builder
+	for _, name := range dkimHeaders {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if strings.EqualFold(fields[i].name, name) {
+				names = append(names, strings.ToLower(name))
+				signed.WriteString(relaxedHeader(fields[i].name, fields[i].value))
+				break
+			}
+		}
+	}
+
+	bodyHash := sha256.Sum256(relaxedBody(body))
+	header := "v=1; a=" + s.algorithm() + "; c=relaxed/relaxed; d=" + s.Domain + "; s=" + s.Selector +
+		"; t=" + strconv.FormatInt(time.Now().Unix(), 10) +
+		"; h=" + strings.Join(names, ":") +
+		"; bh=" + base64.StdEncoding.EncodeToString(bodyHash[:]) +
+		"; b="
+
+	// The signature header itself is hashed with an empty b= & without its CRLF
+	signed.WriteString(strings.TrimSuffix(relaxedHeader("DKIM-Signature", header), "\r\n"))
+	digest := sha256.Sum256([]byte(signed.String()))
+
+	var sig []byte
+	var err error
+	if _, ok := s.key.(ed25519.PrivateKey); ok {
+		sig, err = s.key.Sign(rand.Reader, digest[:], crypto.Hash(0))
+	} else {
+		sig, err = s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(raw)+512)
+	out = append(out, "DKIM-Signature: "+header+base64.StdEncoding.EncodeToString(sig)+"\r\n"...)
+	return append(out, raw...), nil
+}
+
+type headerField struct {
+	name  string
+	value string
+}
+
+// parseHeaderFields - Splits a header block into fields, keeping folded values as they are
+func parseHeaderFields(head string) []headerField {
+	var fields []headerField
+	for _, line := range strings.Split(head, "\r\n") {
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(fields) > 0 {
+			fields[len(fields)-1].value += "\r\n" + line
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields = append(fields, headerField{name: name, value: value})
+	}
+	return fields
+}
+
+// relaxedHeader - RFC 6376 3.4.2: lowercase name, unfolded value with whitespace runs collapsed & trimmed
+func relaxedHeader(name string, value string) string {
+	value = strings.ReplaceAll(value, "\r\n", "")
+	return strings.ToLower(strings.TrimSpace(name)) + ":" + strings.TrimSpace(collapseWSP(value)) + "\r\n"
+}
+
+// relaxedBody - RFC 6376 3.4.4: whitespace runs collapsed, trailing whitespace & empty lines removed
+func relaxedBody(body []byte) []byte {
+	lines := strings.Split(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(collapseWSP(line), " ")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+func collapseWSP(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		if r == ' ' || r == '\t' {
+			space = true
+			continue
+		}
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+	if space {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
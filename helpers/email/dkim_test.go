@@ -0,0 +1,163 @@
+package email
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	msgauth "github.com/emersion/go-msgauth/dkim"
+)
+
+// dkimTestMessage - Folded headers, odd whitespace & a multipart body with trailing blank lines,
+// everything relaxed canonicalization has to get right
+const dkimTestMessage = "From: Acme <no-reply@example.com>\r\n" +
+	"To: jane@example.com\r\n" +
+	"Subject: A rather long subject that the sender\r\n" +
+	" \tfolded over   two lines\r\n" +
+	"Date: Mon, 02 Jan 2026 15:04:05 +0000\r\n" +
+	"Message-ID: <abc@example.com>\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/alternative;\r\n" +
+	" boundary=\"b1\"\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/plain; charset=UTF-8\r\n" +
+	"\r\n" +
+	"Hello   there \t\r\n" +
+	"\r\n" +
+	"--b1\r\n" +
+	"Content-Type: text/html; charset=UTF-8\r\n" +
+	"\r\n" +
+	"<p>Hello there</p>\r\n" +
+	"--b1--\r\n" +
+	"\r\n" +
+	"\r\n"
+
+// newTestSigners - An RSA & an Ed25519 signer for example.com, with the TXT records publishing their public keys
+func newTestSigners(t *testing.T) (map[string]*DKIMSigner, map[string]string) {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPub, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signers := map[string]*DKIMSigner{}
+	records := map[string]string{}
+	for _, k := range []struct {
+		selector string
+		key      any
+		record   string
+	}{
+		{"rsa", rsaKey, "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(rsaPub)},
+		{"ed", edKey, "v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(edPub)},
+	} {
+		der, err := x509.MarshalPKCS8PrivateKey(k.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := NewDKIMSigner("example.com", k.selector, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		signers[k.selector] = s
+		records[k.selector+"._domainkey.example.com"] = k.record
+	}
+	return signers, records
+}
+
+// stubLookup - Answers DNS TXT lookups from records instead of the network
+func stubLookup(records map[string]string) *msgauth.VerifyOptions {
+	return &msgauth.VerifyOptions{
+		LookupTXT: func(domain string) ([]string, error) {
+			if r, ok := records[domain]; ok {
+				return []string{r}, nil
+			}
+			return nil, fmt.Errorf("no TXT record for %s", domain)
+		},
+	}
+}
+
+// verifyDKIM - Checks the signature with an independent implementation, looking the keys up in records
+func verifyDKIM(t *testing.T, signed []byte, records map[string]string) {
+	t.Helper()
+	verifications, err := msgauth.VerifyWithOptions(bytes.NewReader(signed), stubLookup(records))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(verifications) != 1 {
+		t.Fatalf("%d signatures, want 1", len(verifications))
+	}
+	if v := verifications[0]; v.Err != nil || v.Domain != "example.com" {
+		t.Fatalf("verification failed: domain %q: %v\n%s", v.Domain, v.Err, signed)
+	}
+}
+
+func TestDKIMSignatureVerifies(t *testing.T) {
+	signers, records := newTestSigners(t)
+
+	for selector, s := range signers {
+		t.Run(selector, func(t *testing.T) {
+			signed, err := s.Sign([]byte(dkimTestMessage))
+			if err != nil {
+				t.Fatal(err)
+			}
+			verifyDKIM(t, signed, records)
+
+			// Changing the body must break the signature
+			tampered := bytes.Replace(signed, []byte("Hello there</p>"), []byte("Hello thereX</p>"), 1)
+			verifications, err := msgauth.VerifyWithOptions(bytes.NewReader(tampered), stubLookup(records))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(verifications) != 1 || verifications[0].Err == nil {
+				t.Fatal("a tampered body still verifies")
+			}
+		})
+	}
+}
+
+// The message the mailer actually sends (built by gomail, quoted-printable parts) must verify too
+func TestDKIMSignedMessageVerifies(t *testing.T) {
+	signers, records := newTestSigners(t)
+	old := dkim
+	t.Cleanup(func() { dkim = old })
+
+	for selector, s := range signers {
+		t.Run(selector, func(t *testing.T) {
+			dkim = s
+			m := &Message{
+				ID:      "abc@example.com",
+				Date:    time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC),
+				From:    "Acme <no-reply@example.com>",
+				To:      "jane@example.com",
+				ReplyTo: "support@example.com",
+				Subject: "Ünïcödé subject " + strings.Repeat("that goes on ", 8),
+				Text:    "Hello there,\n\nclick the link: https://app.example.com/auth/verify?token=" + strings.Repeat("x", 120),
+				HTML:    "<p>Hello there,</p>\n<p><a href=\"https://app.example.com\">Verify</a></p>",
+				Headers: map[string]string{"Auto-Submitted": "auto-generated"},
+			}
+			signed, err := m.Raw()
+			if err != nil {
+				t.Fatal(err)
+			}
+			verifyDKIM(t, signed, records)
+		})
+	}
+}
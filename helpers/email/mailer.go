@@ -0,0 +1,186 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// Message - An email ready to be handed to a Mailer
+type Message struct {
+	ID      string // Message-ID, without the angle brackets
+	Date    time.Time
+	From    string
+	To      string
+	ReplyTo string
+	Subject string
+	HTML    string
+	Text    string            // text/plain alternative
+	Headers map[string]string // Extra headers (e.g. List-Unsubscribe)
+}
+
+// Raw - The message as MIME bytes, DKIM signed when configured (what every transport sends)
+func (m *Message) Raw() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := m.gomail().WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	if dkim != nil {
+		return dkim.Sign(buf.Bytes())
+	}
+	return buf.Bytes(), nil
+}
+
+func (m *Message) gomail() *gomail.Message {
+	gm := gomail.NewMessage()
+	if m.ID != "" {
+		gm.SetHeader("Message-ID", "<"+m.ID+">")
+	}
+	if !m.Date.IsZero() {
+		gm.SetDateHeader("Date", m.Date)
+	}
+	gm.SetHeader("From", m.From)
+	gm.SetHeader("To", m.To)
+	if m.ReplyTo != "" {
+		gm.SetHeader("Reply-To", m.ReplyTo)
+	}
+	gm.SetHeader("Subject", m.Subject)
+	for k, v := range m.Headers {
+		gm.SetHeader(k, v)
+	}
+
+	// Clients show the last alternative they support, HTML goes last
+	if m.Text != "" {
+		gm.SetBody("text/plain", m.Text)
+		gm.AddAlternative("text/html", m.HTML)
+	} else {
+		gm.SetBody("text/html", m.HTML)
+	}
+	return gm
+}
+
+// Mailer - Something that can deliver an email
+type Mailer interface {
+	Send(ctx context.Context, m *Message) error
+}
+
+var mailer Mailer
+
+// Load - Parses the templates, loads the DKIM key & picks the transport from MAIL_TRANSPORT: "smtp" (default), "ses", "maildir" or "memory"
+func Load() error {
+	if err := LoadTemplates(); err != nil {
+		return err
+	}
+	if err := LoadDKIM(); err != nil {
+		return err
+	}
+	loadCapture()
+	m, err := newMailer(os.Getenv("MAIL_TRANSPORT"))
+	if err != nil {
+		return err
+	}
+	mailer = m
+	return nil
+}
+
+func newMailer(transport string) (Mailer, error) {
+	switch transport {
+	case "", "smtp":
+		port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+		if err != nil {
+			return nil, fmt.Errorf("email: invalid SMTP_PORT: %w", err)
+		}
+		size := 4
+		if v, err := strconv.Atoi(os.Getenv("SMTP_POOL_SIZE")); err == nil && v > 0 {
+			size = v
+		}
+		return NewSMTPMailer(os.Getenv("SMTP_HOST"), port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), size), nil
+	case "ses":
+		return NewSESMailer(context.Background(), os.Getenv("SES_REGION"), os.Getenv("SES_CONFIGURATION_SET"))
+	case "maildir":
+		dir := os.Getenv("MAILDIR_PATH")
+		if dir == "" {
+			return nil, fmt.Errorf("email: MAILDIR_PATH is required")
+		}
+		return NewMaildirMailer(dir)
+	case "memory":
+		// Nothing is delivered, only meant for development
+		if os.Getenv("APP_ENVIRONMENT") != "dev" {
+			return nil, fmt.Errorf("email: MAIL_TRANSPORT=memory requires APP_ENVIRONMENT=dev")
+		}
+		return NewMemoryMailer(), nil
+	default:
+		return nil, fmt.Errorf("email: unknown MAIL_TRANSPORT %q", transport)
+	}
+}
+
+// Use - Replaces the mailer (handler tests pass a *MemoryMailer to inspect what was sent)
+func Use(m Mailer) {
+	mailer = m
+}
+
+// Close - Closes pooled connections, if the mailer keeps any
+func Close() error {
+	if c, ok := mailer.(interface{ Close() error }); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// send - Sends an email from the application with the configured mailer.
+// Fills in the sender, Message-ID, Date & Reply-To (EMAIL_REPLY_TO) & marks it Auto-Submitted.
+func send(ctx context.Context, m *Message) error {
+	if mailer == nil {
+		return errors.New("email: no mailer, call email.Load first")
+	}
+
+	from := os.Getenv("SMTP_FROM")
+	m.From = os.Getenv("APPLICATION_NAME") + " <" + from + ">"
+	if m.ReplyTo == "" {
+		m.ReplyTo = os.Getenv("EMAIL_REPLY_TO")
+	}
+	if m.Date.IsZero() {
+		m.Date = time.Now()
+	}
+	if m.ID == "" {
+		id, err := newMessageID(from)
+		if err != nil {
+			return err
+		}
+		m.ID = id
+	}
+	if m.Headers == nil {
+		m.Headers = map[string]string{}
+	}
+	m.Headers["Auto-Submitted"] = "auto-generated"
+
+	if err := mailer.Send(ctx, m); err != nil {
+		return err
+	}
+	if c := Captured(); c != nil && c != mailer {
+		_ = c.Send(ctx, m)
+	}
+	return nil
+}
+
+// newMessageID - A unique Message-ID on the sender's domain
+func newMessageID(from string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 && i < len(from)-1 {
+		domain = from[i+1:]
+	}
+	return hex.EncodeToString(b) + "@" + domain, nil
+}
@@ -0,0 +1,71 @@
+package email
+
+import (
+	"app/helpers/i18n"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"os"
+)
+
+// Queuer - Where emails are queued, a *sql.Tx (so the email only exists if the rest of the change does) or a *sql.DB
+type Queuer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// queue - Adds an email to the outbox, jobs.SendQueuedEmails renders & sends it.
+// userID ties it to an account (0 if none), whose locale the email is written in.
+func queue(q Queuer, userID int64, to string, name string, data map[string]any) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(`
+		INSERT INTO email_outbox (user_id, recipient, template, data, locale)
+		VALUES (NULLIF($1::bigint, 0), $2, $3, $4::jsonb, COALESCE((SELECT locale FROM users WHERE id = $1), $5))
+		`, userID, to, name, string(raw), i18n.Default)
+	return err
+}
+
+// notifications - Notices about the account rather than a reply to something the user asked for,
+// they get List-Unsubscribe headers pointing at the notification settings
+var notifications = map[string]bool{
+	"new-login.html":     true,
+	"email-changed.html": true,
+	"email-removed.html": true,
+}
+
+// unsubscribeHeaders - List-Unsubscribe (EMAIL_UNSUBSCRIBE_URL, default FRONTEND_URL/profile/notifications)
+// & List-Unsubscribe-Post for one-click unsubscribe (RFC 8058) on notifications, nil for other templates
+func unsubscribeHeaders(name string) map[string]string {
+	if !notifications[name] {
+		return nil
+	}
+	u := os.Getenv("EMAIL_UNSUBSCRIBE_URL")
+	if u == "" && os.Getenv("FRONTEND_URL") != "" {
+		u = os.Getenv("FRONTEND_URL") + "/profile/notifications"
+	}
+	if u == "" {
+		return nil
+	}
+	return map[string]string{
+		"List-Unsubscribe":      "<" + u + ">",
+		"List-Unsubscribe-Post": "List-Unsubscribe=One-Click",
+	}
+}
+
+// Deliver - Renders a queued email's template in the locale & sends it with the configured mailer
+func Deliver(ctx context.Context, to string, locale string, name string, data map[string]any) error {
+	subject, html, text, err := render(locale, name, data)
+	if err != nil {
+		return err
+	}
+
+	return send(ctx, &Message{
+		To:      to,
+		Subject: subject,
+		HTML:    html,
+		Text:    text,
+		Headers: unsubscribeHeaders(name),
+	})
+}
@@ -0,0 +1,94 @@
+package email
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// captureLimit - Messages a MemoryMailer keeps (the development preview & the memory transport)
+const captureLimit = 200
+
+// captured - A copy of every message sent in development (APP_ENVIRONMENT=dev), for /dev/emails
+var captured *MemoryMailer
+
+// Captured - The messages kept for the development preview: the memory transport itself,
+// a copy of what the real transport sent in development, nil otherwise
+func Captured() *MemoryMailer {
+	if m, ok := mailer.(*MemoryMailer); ok {
+		return m
+	}
+	return captured
+}
+
+// loadCapture - Starts keeping sent messages when running in development
+func loadCapture() {
+	captured = nil
+	if os.Getenv("APP_ENVIRONMENT") == "dev" {
+		captured = NewMemoryMailer()
+	}
+}
+
+// TemplateNames - The templates that can be previewed, sorted
+func TemplateNames() []string {
+	var names []string
+	for path := range templates {
+		if !strings.Contains(path, "/") {
+			names = append(names, path)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Preview - Renders a template in the locale with sample data, the way the recipient would get it
+func Preview(locale string, name string) (*Message, error) {
+	subject, html, text, err := render(locale, name, sampleData(name))
+	if err != nil {
+		return nil, err
+	}
+	return &Message{
+		To:      "jane@example.com",
+		Subject: subject,
+		HTML:    html,
+		Text:    text,
+		Headers: unsubscribeHeaders(name),
+	}, nil
+}
+
+// sampleData - Data shaped like what the Send functions queue for the template
+func sampleData(name string) map[string]any {
+	frontend := os.Getenv("FRONTEND_URL")
+	switch name {
+	case "verify-email.html":
+		return map[string]any{"VerificationLink": frontend + "/auth/verify?token=sample"}
+	case "reset-password.html":
+		return map[string]any{"ResetLink": frontend + "/auth/reset?token=sample"}
+	case "change-email.html":
+		return map[string]any{"Link": frontend + "/auth/change-email?token=sample"}
+	case "account-deletion.html":
+		return map[string]any{"CancelLink": frontend + "/auth/restore?token=sample", "Days": 30}
+	case "data-export.html":
+		return map[string]any{"DownloadLink": frontend + "/profile/export?token=sample", "Hours": 24}
+	case "password-changed.html":
+		return map[string]any{"LockLink": frontend + "/auth/lock?token=sample"}
+	case "email-changed.html":
+		return map[string]any{"NewEmail": "jane.doe@example.org", "RevertLink": frontend + "/auth/revert-email?token=sample", "Days": 7}
+	case "email-removed.html":
+		return map[string]any{"Email": "jane@example.com", "LockLink": frontend + "/auth/lock?token=sample"}
+	case "phone-changed.html":
+		return map[string]any{"Phone": "+4*******89", "LockLink": frontend + "/auth/lock?token=sample"}
+	case "two-factor-changed.html":
+		return map[string]any{"Enabled": true, "LockLink": frontend + "/auth/lock?token=sample"}
+	case "new-login.html":
+		return map[string]any{
+			"IP":        "203.0.113.7",
+			"UserAgent": "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_5) Firefox/128.0",
+			"Time":      time.Now().UTC().Format("Jan 2, 2006 15:04 MST"),
+			"LockLink":  frontend + "/auth/lock?token=sample",
+		}
+	default:
+		return map[string]any{}
+	}
+}
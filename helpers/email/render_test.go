@@ -0,0 +1,97 @@
+package email
+
+import (
+	"app/helpers/i18n"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// go test ./helpers/email -run TestTemplatesGolden -update rewrites the golden files after a template change
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// TestTemplatesGolden - Every template in every locale it's translated to, rendered with the preview's sample data.
+// The HTML, the text part & the headers must match testdata/golden/<locale>/<template>.{html,txt,headers}.
+func TestTemplatesGolden(t *testing.T) {
+	t.Setenv("EMAIL_TEMPLATES_DIR", "")
+	t.Setenv("EMAIL_BRAND_COLOR", "")
+	t.Setenv("EMAIL_LOGO_URL", "")
+	t.Setenv("FRONTEND_URL", "https://app.example.com")
+	t.Setenv("EMAIL_REPLY_TO", "support@example.com")
+	t.Setenv("EMAIL_UNSUBSCRIBE_URL", "")
+	m := useMemoryMailer(t)
+
+	for _, name := range TemplateNames() {
+		for _, locale := range i18n.Supported {
+			if _, ok := templates[locale+"/"+name]; !ok && locale != i18n.Default {
+				continue // Sent in English, covered by the English golden file
+			}
+
+			t.Run(locale+"/"+name, func(t *testing.T) {
+				m.Reset()
+				data := sampleData(name)
+				if _, ok := data["Time"]; ok {
+					data["Time"] = "Jan 2, 2026 15:04 UTC"
+				}
+				if err := Deliver(context.Background(), "jane@example.com", locale, name, data); err != nil {
+					t.Fatal(err)
+				}
+				sent := m.Messages()
+				if len(sent) != 1 {
+					t.Fatalf("sent %d messages, want 1", len(sent))
+				}
+				msg := sent[0].Message
+
+				base := filepath.Join("testdata", "golden", locale, strings.TrimSuffix(name, ".html"))
+				checkGolden(t, base+".html", msg.HTML)
+				checkGolden(t, base+".txt", msg.Text)
+				checkGolden(t, base+".headers", goldenHeaders(&msg))
+			})
+		}
+	}
+}
+
+// goldenHeaders - The headers that don't change between sends (Date & Message-ID do)
+func goldenHeaders(m *Message) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\n", m.From)
+	fmt.Fprintf(&b, "To: %s\n", m.To)
+	fmt.Fprintf(&b, "Reply-To: %s\n", m.ReplyTo)
+	fmt.Fprintf(&b, "Subject: %s\n", m.Subject)
+
+	keys := make([]string, 0, len(m.Headers))
+	for k := range m.Headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s: %s\n", k, m.Headers[k])
+	}
+	return b.String()
+}
+
+func checkGolden(t *testing.T, path string, got string) {
+	t.Helper()
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from the rendered email (run with -update if the change is intended)\n--- got:\n%s", path, got)
+	}
+}
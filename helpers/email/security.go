@@ -0,0 +1,55 @@
+package email
+
+import (
+	"time"
+)
+
+// SendPasswordChanged - Tells the user their password was changed (or reset)
+func SendPasswordChanged(q Queuer, userID int64, to string, lockLink string) error {
+	return queue(q, userID, to, "password-changed.html", map[string]any{
+		"LockLink": lockLink,
+	})
+}
+
+// SendEmailChanged - Tells the OLD address the account's email was changed & how to undo it
+func SendEmailChanged(q Queuer, userID int64, to string, newEmail string, revertLink string, days int) error {
+	return queue(q, userID, to, "email-changed.html", map[string]any{
+		"NewEmail":   newEmail,
+		"RevertLink": revertLink,
+		"Days":       days,
+	})
+}
+
+// SendEmailRemoved - Tells an address it was removed from the account
+func SendEmailRemoved(q Queuer, userID int64, to string, lockLink string) error {
+	return queue(q, userID, to, "email-removed.html", map[string]any{
+		"Email":    to,
+		"LockLink": lockLink,
+	})
+}
+
+// SendTwoFactorChanged - Tells the user SMS two-factor authentication was turned on or off
+func SendTwoFactorChanged(q Queuer, userID int64, to string, enabled bool, lockLink string) error {
+	return queue(q, userID, to, "two-factor-changed.html", map[string]any{
+		"Enabled":  enabled,
+		"LockLink": lockLink,
+	})
+}
+
+// SendPhoneChanged - Tells the user a phone number (masked) was verified & can now receive their codes
+func SendPhoneChanged(q Queuer, userID int64, to string, phone string, lockLink string) error {
+	return queue(q, userID, to, "phone-changed.html", map[string]any{
+		"Phone":    phone,
+		"LockLink": lockLink,
+	})
+}
+
+// SendNewLogin - Tells the user about a sign-in from a device they haven't used before
+func SendNewLogin(q Queuer, userID int64, to string, ip string, userAgent string, at time.Time, lockLink string) error {
+	return queue(q, userID, to, "new-login.html", map[string]any{
+		"IP":        ip,
+		"UserAgent": userAgent,
+		"Time":      at.UTC().Format("Jan 2, 2006 15:04 MST"),
+		"LockLink":  lockLink,
+	})
+}
@@ -0,0 +1,246 @@
+package email
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SNSMessage - An Amazon SNS HTTP delivery (notification or subscription confirmation)
+type SNSMessage struct {
+	Type             string `json:"Type"`
+	MessageID        string `json:"MessageId"`
+	Token            string `json:"Token"`
+	TopicArn         string `json:"TopicArn"`
+	Subject          string `json:"Subject"`
+	Message          string `json:"Message"`
+	Timestamp        string `json:"Timestamp"`
+	SignatureVersion string `json:"SignatureVersion"`
+	Signature        string `json:"Signature"`
+	SigningCertURL   string `json:"SigningCertURL"`
+	SubscribeURL     string `json:"SubscribeURL"`
+	UnsubscribeURL   string `json:"UnsubscribeURL"`
+}
+
+var (
+	ErrSNSTopic     = errors.New("email: SNS topic not allowed")
+	ErrSNSSignature = errors.New("email: invalid SNS signature")
+)
+
+// snsHost - SNS endpoints are sns.<region>.amazonaws.com (.com.cn in China)
+var snsHost = regexp.MustCompile(`^sns\.([a-z0-9-]+)\.amazonaws\.com(\.cn)?$`)
+
+// snsMaxAge - Older messages are refused (SNS retries for at most an hour)
+const snsMaxAge = time.Hour
+
+// SNSTopics - Topics the SES webhook accepts (SES_SNS_TOPIC_ARNS, comma separated). Empty disables the webhook.
+func SNSTopics() []string {
+	var topics []string
+	for _, t := range strings.Split(os.Getenv("SES_SNS_TOPIC_ARNS"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			topics = append(topics, t)
+		}
+	}
+	return topics
+}
+
+// VerifySNS - Checks the message comes from one of the topics, signed by SNS in the topic's region
+func VerifySNS(ctx context.Context, m *SNSMessage, topics []string) error {
+	if !slices.Contains(topics, m.TopicArn) {
+		return ErrSNSTopic
+	}
+
+	// The certificate must come from the topic region's endpoint
+	region, ok := snsRegion(m.TopicArn)
+	if !ok {
+		return ErrSNSTopic
+	}
+	if err := CheckSNSURL(m.SigningCertURL, region); err != nil {
+		return err
+	}
+	if u, _ := url.Parse(m.SigningCertURL); u.RawQuery != "" || !strings.HasSuffix(u.Path, ".pem") {
+		return fmt.Errorf("email: unexpected SNS certificate URL %q", m.SigningCertURL)
+	}
+
+	sent, err := time.Parse(time.RFC3339, m.Timestamp)
+	if err != nil || time.Since(sent) > snsMaxAge {
+		return fmt.Errorf("email: stale SNS message (%s)", m.Timestamp)
+	}
+
+	var hash crypto.Hash
+	switch m.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return fmt.Errorf("email: unsupported SNS signature version %q", m.SignatureVersion)
+	}
+	sig, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return ErrSNSSignature
+	}
+
+	cert, err := fetchSNSCert(ctx, m.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("email: the SNS certificate doesn't hold an RSA key")
+	}
+
+	var digest []byte
+	if hash == crypto.SHA1 {
+		sum := sha1.Sum(snsStringToSign(m))
+		digest = sum[:]
+	} else {
+		sum := sha256.Sum256(snsStringToSign(m))
+		digest = sum[:]
+	}
+	if rsa.VerifyPKCS1v15(key, hash, digest, sig) != nil {
+		return ErrSNSSignature
+	}
+	return nil
+}
+
+// snsRegion - The region of a topic ARN (arn:aws:sns:<region>:<account>:<topic>)
+func snsRegion(topicArn string) (string, bool) {
+	arn := strings.Split(topicArn, ":")
+	if len(arn) != 6 || arn[0] != "arn" || arn[2] != "sns" || arn[3] == "" {
+		return "", false
+	}
+	return arn[3], true
+}
+
+// CheckSNSURL - The URL must be HTTPS on the SNS endpoint of the region
+func CheckSNSURL(raw string, region string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	host := snsHost.FindStringSubmatch(u.Hostname())
+	if u.Scheme != "https" || u.Port() != "" || host == nil || host[1] != region {
+		return fmt.Errorf("email: not an SNS URL for %s: %q", region, raw)
+	}
+	return nil
+}
+
+// snsStringToSign - The fields SNS signs, in order, as "Name\nvalue\n"
+func snsStringToSign(m *SNSMessage) []byte {
+	fields := [][2]string{{"Message", m.Message}, {"MessageId", m.MessageID}}
+	if m.Type == "Notification" {
+		if m.Subject != "" {
+			fields = append(fields, [2]string{"Subject", m.Subject})
+		}
+		fields = append(fields, [2]string{"Timestamp", m.Timestamp}, [2]string{"TopicArn", m.TopicArn})
+	} else {
+		fields = append(fields,
+			[2]string{"SubscribeURL", m.SubscribeURL},
+			[2]string{"Timestamp", m.Timestamp},
+			[2]string{"Token", m.Token},
+			[2]string{"TopicArn", m.TopicArn},
+		)
+	}
+	fields = append(fields, [2]string{"Type", m.Type})
+
+	var b strings.Builder
+	for _, f := range fields {
+		b.WriteString(f[0] + "\n" + f[1] + "\n")
+	}
+	return []byte(b.String())
+}
+
+// snsClient - Doesn't follow redirects, they could leave the SNS host
+var snsClient = &http.Client{
+	Timeout: 10 * time.Second,
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// ConfirmSNSSubscription - Visits the SubscribeURL of a verified subscription confirmation (HTTPS, same SNS region)
+func ConfirmSNSSubscription(ctx context.Context, m *SNSMessage) error {
+	region, ok := snsRegion(m.TopicArn)
+	if !ok {
+		return ErrSNSTopic
+	}
+	if err := CheckSNSURL(m.SubscribeURL, region); err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.SubscribeURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := snsClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("email: SNS answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// snsCerts - Signing certificates by URL, SNS rotates them rarely
+var snsCerts sync.Map
+
+// fetchSNSCert - Downloads (once) the signing certificate, replaced in tests
+var fetchSNSCert = func(ctx context.Context, certURL string) (*x509.Certificate, error) {
+	if c, ok := snsCerts.Load(certURL); ok {
+		return c.(*x509.Certificate), nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, certURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := snsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("email: SNS certificate answered %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(body)
+	if block == nil {
+		return nil, errors.New("email: the SNS certificate is not PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if now := time.Now(); now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return nil, errors.New("email: the SNS certificate expired")
+	}
+	snsCerts.Store(certURL, cert)
+	return cert, nil
+}
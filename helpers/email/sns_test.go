@@ -0,0 +1,166 @@
+package email
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"testing"
+	"time"
+)
+
+const snsTestTopic = "arn:aws:sns:eu-west-1:123456789012:ses-bounces"
+
+// useSNSCert - A self-signed certificate served for every URL instead of downloading it, returns its key
+func useSNSCert(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old := fetchSNSCert
+	t.Cleanup(func() { fetchSNSCert = old })
+	fetchSNSCert = func(context.Context, string) (*x509.Certificate, error) {
+		return cert, nil
+	}
+	return key
+}
+
+// signSNS - Signs the message the way SNS does for its SignatureVersion
+func signSNS(t *testing.T, key *rsa.PrivateKey, m *SNSMessage) {
+	t.Helper()
+	var (
+		hash   crypto.Hash
+		digest []byte
+	)
+	if m.SignatureVersion == "1" {
+		sum := sha1.Sum(snsStringToSign(m))
+		hash, digest = crypto.SHA1, sum[:]
+	} else {
+		sum := sha256.Sum256(snsStringToSign(m))
+		hash, digest = crypto.SHA256, sum[:]
+	}
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, hash, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Signature = base64.StdEncoding.EncodeToString(sig)
+}
+
+func testSNSMessage(version string) *SNSMessage {
+	return &SNSMessage{
+		Type:             "Notification",
+		MessageID:        "22b80b92-fdea-4c2c-8f9d-bdfb0c7bf324",
+		TopicArn:         snsTestTopic,
+		Message:          `{"notificationType":"Bounce"}`,
+		Timestamp:        time.Now().UTC().Format(time.RFC3339),
+		SignatureVersion: version,
+		SigningCertURL:   "https://sns.eu-west-1.amazonaws.com/SimpleNotificationService-abc.pem",
+	}
+}
+
+func TestVerifySNS(t *testing.T) {
+	key := useSNSCert(t)
+	topics := []string{snsTestTopic}
+
+	tests := []struct {
+		name   string
+		change func(m *SNSMessage) // Applied after signing unless resign is set
+		resign bool
+		ok     bool
+	}{
+		{"valid", func(m *SNSMessage) {}, false, true},
+		{"subscription confirmation", func(m *SNSMessage) {
+			m.Type = "SubscriptionConfirmation"
+			m.Token = "token"
+			m.SubscribeURL = "https://sns.eu-west-1.amazonaws.com/?Action=ConfirmSubscription&Token=token"
+		}, true, true},
+		{"tampered message", func(m *SNSMessage) { m.Message = `{"notificationType":"Complaint"}` }, false, false},
+		{"tampered signature", func(m *SNSMessage) { m.Signature = base64.StdEncoding.EncodeToString([]byte("nope")) }, false, false},
+		{"other topic", func(m *SNSMessage) { m.TopicArn = "arn:aws:sns:eu-west-1:123456789012:other" }, true, false},
+		{"malformed topic", func(m *SNSMessage) { m.TopicArn = "eu-west-1" }, true, false},
+		{"S3 bucket host", func(m *SNSMessage) {
+			m.SigningCertURL = "https://sns.x.s3.amazonaws.com/SimpleNotificationService-abc.pem"
+		}, true, false},
+		{"other region", func(m *SNSMessage) {
+			m.SigningCertURL = "https://sns.us-east-1.amazonaws.com/SimpleNotificationService-abc.pem"
+		}, true, false},
+		{"plain HTTP", func(m *SNSMessage) {
+			m.SigningCertURL = "http://sns.eu-west-1.amazonaws.com/SimpleNotificationService-abc.pem"
+		}, true, false},
+		{"lookalike host", func(m *SNSMessage) {
+			m.SigningCertURL = "https://sns.eu-west-1.amazonaws.com.example.com/SimpleNotificationService-abc.pem"
+		}, true, false},
+		{"not a certificate", func(m *SNSMessage) {
+			m.SigningCertURL = "https://sns.eu-west-1.amazonaws.com/?Action=Unsubscribe"
+		}, true, false},
+		{"certificate in the query", func(m *SNSMessage) {
+			m.SigningCertURL = "https://sns.eu-west-1.amazonaws.com/?Action=Unsubscribe&x=.pem"
+		}, true, false},
+		{"stale", func(m *SNSMessage) {
+			m.Timestamp = time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)
+		}, true, false},
+		{"unknown version", func(m *SNSMessage) { m.SignatureVersion = "3" }, false, false},
+	}
+
+	for _, version := range []string{"1", "2"} {
+		for _, tt := range tests {
+			t.Run("v"+version+"/"+tt.name, func(t *testing.T) {
+				m := testSNSMessage(version)
+				if tt.resign {
+					tt.change(m)
+					signSNS(t, key, m)
+				} else {
+					signSNS(t, key, m)
+					tt.change(m)
+				}
+
+				err := VerifySNS(context.Background(), m, topics)
+				if tt.ok && err != nil {
+					t.Fatalf("rejected: %v", err)
+				}
+				if !tt.ok && err == nil {
+					t.Fatal("accepted")
+				}
+			})
+		}
+	}
+}
+
+func TestConfirmSNSSubscriptionChecksURL(t *testing.T) {
+	for _, u := range []string{
+		"https://sns.x.s3.amazonaws.com/?Action=ConfirmSubscription",
+		"https://sns.us-east-1.amazonaws.com/?Action=ConfirmSubscription",
+		"https://evil.example.com/?Action=ConfirmSubscription",
+	} {
+		m := &SNSMessage{Type: "SubscriptionConfirmation", TopicArn: snsTestTopic, SubscribeURL: u}
+		if err := ConfirmSNSSubscription(context.Background(), m); err == nil {
+			t.Errorf("%s was visited", u)
+		}
+	}
+	m := &SNSMessage{TopicArn: "nope", SubscribeURL: "https://sns.eu-west-1.amazonaws.com/"}
+	if err := ConfirmSNSSubscription(context.Background(), m); err == nil {
+		t.Error("a malformed topic was accepted")
+	}
+}
@@ -0,0 +1,81 @@
+package email
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// Suppression reasons
+const (
+	ReasonBounce    = "bounce"
+	ReasonComplaint = "complaint"
+)
+
+// IsSuppressed - Whether address hard bounced or complained, nothing is sent to it anymore
+func IsSuppressed(db *sql.DB, address string) (bool, error) {
+	var suppressed bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM email_suppressions WHERE email = $1)`, normalizeAddress(address)).
+		Scan(&suppressed)
+	return suppressed, err
+}
+
+// Suppress - Adds address to the suppression list. Returns the IDs of the accounts using it (verified or primary),
+// they see it flagged undeliverable (see UndeliverableSQL).
+func Suppress(db *sql.DB, address string, reason string, detail string) ([]int64, error) {
+	address = normalizeAddress(address)
+	if address == "" {
+		return nil, errors.New("email: empty address")
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// A complaint outranks an earlier bounce
+	_, err = tx.Exec(`
+		INSERT INTO email_suppressions (email, reason, detail)
+		VALUES ($1, $2, NULLIF($3, ''))
+		ON CONFLICT (email) DO UPDATE
+		SET reason = CASE WHEN EXCLUDED.reason = 'complaint' THEN EXCLUDED.reason ELSE email_suppressions.reason END,
+		    detail = COALESCE(EXCLUDED.detail, email_suppressions.detail)
+		`, address, reason, detail)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`
+		SELECT DISTINCT user_id FROM user_emails
+		WHERE lower(email) = $1 AND (verified OR is_primary)
+		`, address)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err = rows.Scan(&id); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, tx.Commit()
+}
+
+// UndeliverableSQL - Whether the user_emails row aliased e is suppressed, as an SQL expression.
+// The one place both the profile (primary address) & the address list read it from.
+const UndeliverableSQL = `EXISTS (SELECT 1 FROM email_suppressions s WHERE s.email = lower(e.email))`
+
+func normalizeAddress(address string) string {
+	return strings.ToLower(strings.TrimSpace(address))
+}
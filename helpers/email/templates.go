@@ -0,0 +1,158 @@
+package email
+
+import (
+	"app/helpers/i18n"
+	"bytes"
+	"embed"
+	"errors"
+	"fmt"
+	stdhtml "html"
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+)
+
+//go:embed templates/*.html templates/*/*.html
+var embedded embed.FS
+
+// layoutName - The base layout every template is rendered in (it defines "layout" & uses "title" & "content")
+const layoutName = "layout.html"
+
+// Branding - The app details templates get as .App
+type Branding struct {
+	Name    string // APPLICATION_NAME
+	Color   string // EMAIL_BRAND_COLOR, buttons (default #111)
+	LogoURL string // EMAIL_LOGO_URL, shown above the title when set
+	URL     string // FRONTEND_URL, linked in the footer
+}
+
+var (
+	templates     map[string]*template.Template
+	textTemplates map[string]*texttemplate.Template // Optional sibling .txt templates
+	branding      Branding
+)
+
+// LoadTemplates - Parses the templates once. Locales can have their own copy of a template in a sub directory
+// named after them (e.g. de/verify-email.html), otherwise the English one is used.
+// The embedded defaults can be replaced file by file (layout included) with files of the same path in EMAIL_TEMPLATES_DIR.
+func LoadTemplates() error {
+	dir := os.Getenv("EMAIL_TEMPLATES_DIR")
+	read := func(name string) ([]byte, error) {
+		if dir != "" {
+			b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+			if err == nil {
+				return b, nil
+			}
+			if !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+		}
+		return embedded.ReadFile("templates/" + name)
+	}
+
+	// Base layout
+	src, err := read(layoutName)
+	if err != nil {
+		return err
+	}
+	layout, err := template.New(layoutName).Parse(string(src))
+	if err != nil {
+		return fmt.Errorf("email: %s: %w", layoutName, err)
+	}
+
+	// Every template (& its translations), parsed into its own copy of the layout
+	entries, err := embedded.ReadDir("templates")
+	if err != nil {
+		return err
+	}
+	parsed := make(map[string]*template.Template)
+	parsedText := make(map[string]*texttemplate.Template)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || name == layoutName || !strings.HasSuffix(name, ".html") {
+			continue
+		}
+		for _, locale := range i18n.Supported {
+			path := name
+			if locale != i18n.Default {
+				path = locale + "/" + name
+			}
+			if src, err = read(path); errors.Is(err, fs.ErrNotExist) && locale != i18n.Default {
+				continue
+			} else if err != nil {
+				return err
+			}
+			t, err := template.Must(layout.Clone()).New(name).Parse(string(src))
+			if err != nil {
+				return fmt.Errorf("email: %s: %w", path, err)
+			}
+			parsed[path] = t
+
+			// Hand-written text version (generated from the HTML otherwise)
+			textPath := strings.TrimSuffix(path, ".html") + ".txt"
+			if src, err = read(textPath); err == nil {
+				tt, err := texttemplate.New(textPath).Parse(string(src))
+				if err != nil {
+					return fmt.Errorf("email: %s: %w", textPath, err)
+				}
+				parsedText[path] = tt
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	templates = parsed
+	textTemplates = parsedText
+
+	branding = Branding{
+		Name:    os.Getenv("APPLICATION_NAME"),
+		Color:   os.Getenv("EMAIL_BRAND_COLOR"),
+		LogoURL: os.Getenv("EMAIL_LOGO_URL"),
+		URL:     os.Getenv("FRONTEND_URL"),
+	}
+	if branding.Color == "" {
+		branding.Color = "#111"
+	}
+	return nil
+}
+
+// render - Renders a template in the locale (or English) inside the layout, with the branding as .App.
+// The subject is the template's title, the text version comes from its .txt sibling or from the HTML.
+func render(locale string, name string, data map[string]any) (subject string, html string, text string, err error) {
+	path := locale + "/" + name
+	t, ok := templates[path]
+	if !ok {
+		locale, path = i18n.Default, name
+		if t, ok = templates[name]; !ok {
+			return "", "", "", fmt.Errorf("email: unknown template %q", name)
+		}
+	}
+
+	withApp := make(map[string]any, len(data)+2)
+	for k, v := range data {
+		withApp[k] = v
+	}
+	withApp["App"] = branding
+	withApp["Locale"] = locale
+
+	var title, body bytes.Buffer
+	if err = t.ExecuteTemplate(&title, "title", withApp); err != nil {
+		return "", "", "", err
+	}
+	if err = t.ExecuteTemplate(&body, "layout", withApp); err != nil {
+		return "", "", "", err
+	}
+	subject, html = strings.TrimSpace(stdhtml.UnescapeString(title.String())), body.String()
+
+	if tt, ok := textTemplates[path]; ok {
+		var b bytes.Buffer
+		if err = tt.Execute(&b, withApp); err != nil {
+			return "", "", "", err
+		}
+		return subject, html, b.String(), nil
+	}
+	return subject, html, htmlToText(html), nil
+}
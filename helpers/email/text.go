@@ -0,0 +1,127 @@
+package email
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// htmlToText - Turns a rendered HTML email into its text/plain alternative.
+// Blocks become lines, links keep their URL & the head is dropped.
+func htmlToText(src string) string {
+	var out strings.Builder
+	var href, linkText string
+	inLink, skip := false, 0
+
+	newline := func() {
+		s := out.String()
+		if s != "" && !strings.HasSuffix(s, "\n\n") {
+			out.WriteString("\n")
+		}
+	}
+
+	z := html.NewTokenizer(strings.NewReader(src))
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			// io.EOF once the document is read
+			return tidyText(out.String())
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "head", "style", "script":
+				if tt == html.StartTagToken {
+					skip++
+				}
+			case "br":
+				out.WriteString("\n")
+			case "p", "div", "h1", "h2", "h3", "tr", "li":
+				newline()
+			case "a":
+				inLink, href, linkText = true, "", ""
+				for {
+					key, val, more := z.TagAttr()
+					if string(key) == "href" {
+						href = string(val)
+					}
+					if !more {
+						break
+					}
+				}
+			}
+
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			switch string(name) {
+			case "head", "style", "script":
+				if skip > 0 {
+					skip--
+				}
+			case "p", "div", "h1", "h2", "h3", "tr", "li":
+				newline()
+			case "a":
+				label := strings.TrimSpace(linkText)
+				switch {
+				case href == "" || label == href:
+					out.WriteString(label)
+				case label == "":
+					out.WriteString(href)
+				default:
+					out.WriteString(label + ": " + href)
+				}
+				inLink = false
+			}
+
+		case html.TextToken:
+			if skip > 0 {
+				continue
+			}
+			text := collapseSpaces(string(z.Text()))
+			if inLink {
+				linkText += text
+				continue
+			}
+			out.WriteString(text)
+		}
+	}
+}
+
+// collapseSpaces - Collapses runs of whitespace (as a browser would)
+func collapseSpaces(s string) string {
+	var b bytes.Buffer
+	space := false
+	for _, r := range s {
+		if r == ' ' || r == '\n' || r == '\t' || r == '\r' {
+			if !space {
+				b.WriteByte(' ')
+			}
+			space = true
+			continue
+		}
+		space = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// tidyText - Trims every line & keeps at most one blank line between paragraphs
+func tidyText(s string) string {
+	var lines []string
+	blank := true
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			if !blank {
+				lines = append(lines, "")
+			}
+			blank = true
+			continue
+		}
+		lines = append(lines, line)
+		blank = false
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")) + "\n"
+}
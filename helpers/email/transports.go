@@ -0,0 +1,363 @@
+package email
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+)
+
+// smtpIdleTimeout - Pooled connections unused for longer are redialed (servers drop idle clients)
+const smtpIdleTimeout = 30 * time.Second
+
+// smtpTimeout - I/O deadline of a dial or send whose context has none, so a stalled server can't hang a sender
+const smtpTimeout = time.Minute
+
+// SMTPMailer - Sends over SMTP (implicit TLS on port 465, STARTTLS when offered),
+// keeping up to size connections open between emails
+type SMTPMailer struct {
+	host     string
+	port     int
+	username string
+	password string
+	pool     chan *smtpConn
+}
+
+type smtpConn struct {
+	conn     net.Conn
+	client   *smtp.Client
+	lastUsed time.Time
+}
+
+func NewSMTPMailer(host string, port int, username string, password string, size int) *SMTPMailer {
+	return &SMTPMailer{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		pool:     make(chan *smtpConn, size),
+	}
+}
+
+func (s *SMTPMailer) Send(ctx context.Context, m *Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Send the raw bytes so the DKIM signature covers exactly what goes out
+	raw, err := m.Raw()
+	if err != nil {
+		return err
+	}
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return err
+	}
+
+	// A pooled connection may have been dropped by the server, retry once on a fresh one
+	conn, pooled, err := s.get(ctx)
+	if err != nil {
+		return err
+	}
+	err = conn.send(ctx, from.Address, m.To, raw)
+	if err != nil && pooled && ctx.Err() == nil {
+		conn.close()
+		if conn, err = s.dial(ctx); err != nil {
+			return err
+		}
+		err = conn.send(ctx, from.Address, m.To, raw)
+	}
+	if err != nil {
+		conn.close()
+		return err
+	}
+	s.put(conn)
+	return nil
+}
+
+// get - A pooled connection if one is fresh enough, a new one otherwise
+func (s *SMTPMailer) get(ctx context.Context) (*smtpConn, bool, error) {
+	for {
+		select {
+		case conn := <-s.pool:
+			if time.Since(conn.lastUsed) < smtpIdleTimeout {
+				return conn, true, nil
+			}
+			conn.close()
+		default:
+			conn, err := s.dial(ctx)
+			return conn, false, err
+		}
+	}
+}
+
+// dial - Connects, says hello, upgrades to TLS & authenticates, all within ctx
+func (s *SMTPMailer) dial(ctx context.Context) (*smtpConn, error) {
+	addr := net.JoinHostPort(s.host, strconv.Itoa(s.port))
+	tlsConfig := &tls.Config{ServerName: s.host}
+
+	var conn net.Conn
+	var err error
+	if s.port == 465 {
+		conn, err = (&tls.Dialer{Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	stop := deadlineFrom(ctx, conn)
+	defer stop()
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err == nil {
+		if ok, _ := c.Extension("STARTTLS"); ok && s.port != 465 {
+			err = c.StartTLS(tlsConfig)
+		}
+	}
+	if err == nil && s.username != "" {
+		if ok, mechanisms := c.Extension("AUTH"); ok {
+			err = c.Auth(s.auth(mechanisms))
+		}
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return &smtpConn{conn: conn, client: c}, nil
+}
+
+// auth - CRAM-MD5 or LOGIN when the server prefers them, PLAIN otherwise (net/smtp only sends it over TLS)
+func (s *SMTPMailer) auth(mechanisms string) smtp.Auth {
+	offered := strings.Fields(mechanisms)
+	switch {
+	case slices.Contains(offered, "CRAM-MD5"):
+		return smtp.CRAMMD5Auth(s.username, s.password)
+	case slices.Contains(offered, "LOGIN") && !slices.Contains(offered, "PLAIN"):
+		return &loginAuth{username: s.username, password: s.password}
+	default:
+		return smtp.PlainAuth("", s.username, s.password, s.host)
+	}
+}
+
+// send - One SMTP transaction, bounded by ctx (or smtpTimeout)
+func (c *smtpConn) send(ctx context.Context, from string, to string, raw []byte) error {
+	stop := deadlineFrom(ctx, c.conn)
+	defer stop()
+
+	if err := c.client.Mail(from); err != nil {
+		return err
+	}
+	if err := c.client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(raw); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func (c *smtpConn) close() {
+	_ = c.conn.SetDeadline(time.Now().Add(time.Second))
+	_ = c.client.Quit()
+	_ = c.conn.Close()
+}
+
+// deadlineFrom - Sets the connection's I/O deadline from ctx & interrupts it when ctx is cancelled.
+// stop clears the deadline so the connection can go back to the pool.
+func deadlineFrom(ctx context.Context, conn net.Conn) (stop func()) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(smtpTimeout)
+	}
+	_ = conn.SetDeadline(deadline)
+	cancel := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Now())
+	})
+	return func() {
+		cancel()
+		_ = conn.SetDeadline(time.Time{})
+	}
+}
+
+// loginAuth - The LOGIN mechanism (some servers, e.g. Office 365, offer nothing else), only over TLS
+type loginAuth struct {
+	username string
+	password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS {
+		return "", nil, errors.New("email: unencrypted connection")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSuffix(string(fromServer), ":")) {
+	case "username":
+		return []byte(a.username), nil
+	case "password":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("email: unexpected LOGIN challenge %q", fromServer)
+}
+
+// put - Returns a connection to the pool (closing it when the pool is full)
+func (s *SMTPMailer) put(conn *smtpConn) {
+	conn.lastUsed = time.Now()
+	select {
+	case s.pool <- conn:
+	default:
+		conn.close()
+	}
+}
+
+// Close - Closes the pooled connections
+func (s *SMTPMailer) Close() error {
+	for {
+		select {
+		case conn := <-s.pool:
+			conn.close()
+		default:
+			return nil
+		}
+	}
+}
+
+// SESMailer - Sends raw messages through Amazon SES (credentials come from the usual AWS sources)
+type SESMailer struct {
+	client           *sesv2.Client
+	configurationSet string
+}
+
+func NewSESMailer(ctx context.Context, region string, configurationSet string) (*SESMailer, error) {
+	var opts []func(*config.LoadOptions) error
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("email: failed to load the AWS config: %w", err)
+	}
+	return &SESMailer{
+		client:           sesv2.NewFromConfig(cfg),
+		configurationSet: configurationSet,
+	}, nil
+}
+
+func (s *SESMailer) Send(ctx context.Context, m *Message) error {
+	raw, err := m.Raw()
+	if err != nil {
+		return err
+	}
+	in := &sesv2.SendEmailInput{
+		Destination: &types.Destination{ToAddresses: []string{m.To}},
+		Content:     &types.EmailContent{Raw: &types.RawMessage{Data: raw}},
+	}
+	if s.configurationSet != "" {
+		in.ConfigurationSetName = aws.String(s.configurationSet)
+	}
+	_, err = s.client.SendEmail(ctx, in)
+	return err
+}
+
+// MaildirMailer - Delivers into a Maildir (Dir/new), readable by any mail client. Meant for local development.
+type MaildirMailer struct {
+	Dir string
+}
+
+func NewMaildirMailer(dir string) (*MaildirMailer, error) {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o700); err != nil {
+			return nil, err
+		}
+	}
+	return &MaildirMailer{Dir: dir}, nil
+}
+
+func (d *MaildirMailer) Send(_ context.Context, m *Message) error {
+	raw, err := m.Raw()
+	if err != nil {
+		return err
+	}
+
+	// Write to tmp then move to new, so readers never see half a message
+	b := make([]byte, 8)
+	if _, err = rand.Read(b); err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	name := fmt.Sprintf("%d.%s.%s", time.Now().UnixNano(), hex.EncodeToString(b), host)
+	tmp := filepath.Join(d.Dir, "tmp", name)
+	if err = os.WriteFile(tmp, raw, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(d.Dir, "new", name))
+}
+
+// SentMessage - A message captured by a MemoryMailer
+type SentMessage struct {
+	Message
+	SentAt time.Time
+}
+
+// MemoryMailer - Keeps sent messages in memory instead of delivering them (tests & development)
+type MemoryMailer struct {
+	mu    sync.Mutex
+	sent  []SentMessage
+	limit int // Oldest messages are dropped past it
+}
+
+// NewMemoryMailer - Keeps the last captureLimit messages
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{limit: captureLimit}
+}
+
+func (c *MemoryMailer) Send(_ context.Context, m *Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = append(c.sent, SentMessage{Message: *m, SentAt: time.Now()})
+	if c.limit > 0 && len(c.sent) > c.limit {
+		c.sent = append([]SentMessage(nil), c.sent[len(c.sent)-c.limit:]...)
+	}
+	return nil
+}
+
+// Messages - A copy of the captured messages, oldest first
+func (c *MemoryMailer) Messages() []SentMessage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]SentMessage(nil), c.sent...)
+}
+
+// Reset - Forgets the captured messages
+func (c *MemoryMailer) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = nil
+}
@@ -0,0 +1,215 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useMemoryMailer - Swaps the mailer for a MemoryMailer for the length of the test
+func useMemoryMailer(t *testing.T) *MemoryMailer {
+	t.Helper()
+	t.Setenv("APPLICATION_NAME", "Acme")
+	t.Setenv("SMTP_FROM", "no-reply@example.com")
+	if err := LoadTemplates(); err != nil {
+		t.Fatal(err)
+	}
+
+	old, oldCaptured := mailer, captured
+	t.Cleanup(func() { mailer, captured = old, oldCaptured })
+	m := NewMemoryMailer()
+	Use(m)
+	captured = nil
+	return m
+}
+
+func TestDeliverWithMemoryMailer(t *testing.T) {
+	m := useMemoryMailer(t)
+
+	link := "https://app.example.com/auth/reset?token=abc"
+	if err := Deliver(context.Background(), "jane@example.com", "en", "reset-password.html", map[string]any{"ResetLink": link}); err != nil {
+		t.Fatal(err)
+	}
+
+	sent := m.Messages()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(sent))
+	}
+	got := sent[0].Message
+	if got.To != "jane@example.com" || got.From != "Acme <no-reply@example.com>" {
+		t.Errorf("To/From = %q / %q", got.To, got.From)
+	}
+	if got.Subject == "" || got.ID == "" || got.Date.IsZero() {
+		t.Errorf("missing subject, Message-ID or date: %+v", got)
+	}
+	if !strings.Contains(got.HTML, link) || !strings.Contains(got.Text, link) {
+		t.Errorf("the link is missing from the HTML or text part")
+	}
+	if got.Headers["Auto-Submitted"] != "auto-generated" {
+		t.Errorf("Auto-Submitted = %q", got.Headers["Auto-Submitted"])
+	}
+
+	m.Reset()
+	if n := len(m.Messages()); n != 0 {
+		t.Fatalf("%d messages left after Reset", n)
+	}
+}
+
+// A server that accepts the connection but never answers must not hang the sender past its context
+func TestSMTPMailerHonoursContext(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = ln.Close()
+	}()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer func() {
+				_ = conn.Close()
+			}()
+		}
+	}()
+
+	addr := ln.Addr().(*net.TCPAddr)
+	s := NewSMTPMailer("127.0.0.1", addr.Port, "", "", 1)
+	m := &Message{From: "Acme <no-reply@example.com>", To: "jane@example.com", Subject: "Hi", Text: "Hi", HTML: "<p>Hi</p>"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err = s.Send(ctx, m); err == nil {
+		t.Fatal("Send succeeded against a silent server")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Fatalf("Send took %v, the context allowed 200ms", d)
+	}
+}
+
+// fakeSMTPServer - Accepts plain SMTP & sends every DATA payload on the channel
+func fakeSMTPServer(t *testing.T) (port int, received chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = ln.Close()
+	})
+
+	received = make(chan string, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() {
+					_ = conn.Close()
+				}()
+				r := bufio.NewReader(conn)
+				reply := func(s string) { _, _ = conn.Write([]byte(s + "\r\n")) }
+				reply("220 localhost ESMTP")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch cmd := strings.ToUpper(strings.Fields(line + " x")[0]); cmd {
+					case "EHLO":
+						reply("250 localhost")
+					case "MAIL", "RCPT":
+						reply("250 OK")
+					case "DATA":
+						reply("354 Go ahead")
+						var data strings.Builder
+						for {
+							l, err := r.ReadString('\n')
+							if err != nil {
+								return
+							}
+							if l == ".\r\n" {
+								break
+							}
+							data.WriteString(l)
+						}
+						received <- data.String()
+						reply("250 Queued")
+					case "QUIT":
+						reply("221 Bye")
+						return
+					default:
+						reply("502 Not implemented")
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port, received
+}
+
+func TestSMTPMailerReusesConnections(t *testing.T) {
+	port, received := fakeSMTPServer(t)
+	s := NewSMTPMailer("127.0.0.1", port, "", "", 1)
+	defer func() {
+		_ = s.Close()
+	}()
+
+	for i := 0; i < 2; i++ {
+		m := &Message{From: "Acme <no-reply@example.com>", To: "jane@example.com", Subject: "Hi", Text: "Hello there", HTML: "<p>Hello there</p>"}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := s.Send(ctx, m)
+		cancel()
+		if err != nil {
+			t.Fatalf("send %d: %v", i, err)
+		}
+		select {
+		case data := <-received:
+			if !strings.Contains(data, "Subject: Hi") || !strings.Contains(data, "Hello there") {
+				t.Fatalf("unexpected message:\n%s", data)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("the server got nothing")
+		}
+	}
+	if n := len(s.pool); n != 1 {
+		t.Fatalf("%d pooled connections, want 1", n)
+	}
+}
+
+func TestMemoryMailerKeepsTheLatest(t *testing.T) {
+	m := NewMemoryMailer()
+	for i := 0; i < captureLimit+10; i++ {
+		if err := m.Send(context.Background(), &Message{Subject: strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sent := m.Messages()
+	if len(sent) != captureLimit {
+		t.Fatalf("kept %d messages, want %d", len(sent), captureLimit)
+	}
+	if sent[0].Subject != "10" || sent[len(sent)-1].Subject != strconv.Itoa(captureLimit+9) {
+		t.Fatalf("kept %s to %s", sent[0].Subject, sent[len(sent)-1].Subject)
+	}
+}
+
+func TestMemoryTransportOnlyInDev(t *testing.T) {
+	t.Setenv("APP_ENVIRONMENT", "production")
+	if _, err := newMailer("memory"); err == nil {
+		t.Fatal("the memory transport was allowed outside dev")
+	}
+	t.Setenv("APP_ENVIRONMENT", "dev")
+	if _, err := newMailer("memory"); err != nil {
+		t.Fatal(err)
+	}
+}
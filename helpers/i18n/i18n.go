@@ -0,0 +1,90 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/text/language"
+)
+
+//go:embed locales/*.json
+var files embed.FS
+
+// Default - The locale used when nothing better matches, every message exists in it
+const Default = "en"
+
+// Supported - The locales with a message catalog (Default first, the matcher prefers it on ties)
+var Supported = []string{Default, "de", "fr", "es", "it"}
+
+var (
+	messages = map[string]map[string]string{}
+	matcher  language.Matcher
+)
+
+func init() {
+	tags := make([]language.Tag, len(Supported))
+	for i, locale := range Supported {
+		tags[i] = language.MustParse(locale)
+
+		raw, err := files.ReadFile("locales/" + locale + ".json")
+		if err != nil {
+			panic(err)
+		}
+		catalog := map[string]string{}
+		if err = json.Unmarshal(raw, &catalog); err != nil {
+			panic(fmt.Sprintf("i18n: locales/%s.json: %v", locale, err))
+		}
+		messages[locale] = catalog
+	}
+	matcher = language.NewMatcher(tags)
+}
+
+// Match - The best supported locale for an Accept-Language header (Default if none fits)
+func Match(acceptLanguage string) string {
+	prefs, _, err := language.ParseAcceptLanguage(acceptLanguage)
+	if err != nil || len(prefs) == 0 {
+		return Default
+	}
+	_, i, confidence := matcher.Match(prefs...)
+	if confidence == language.No {
+		return Default
+	}
+	return Supported[i]
+}
+
+// FromRequest - The locale the client asked for with Accept-Language
+func FromRequest(r *http.Request) string {
+	return Match(r.Header.Get("Accept-Language"))
+}
+
+// Normalize - Turns a user-picked locale ("de", "DE", "de-AT"...) into a supported one
+func Normalize(locale string) (string, bool) {
+	tag, err := language.Parse(strings.TrimSpace(locale))
+	if err != nil {
+		return "", false
+	}
+	base, _ := tag.Base()
+	for _, s := range Supported {
+		if s == base.String() {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+// T - The message for key in the locale (falling back to Default, then to the key), formatted with args
+func T(locale string, key string, args ...any) string {
+	msg, ok := messages[locale][key]
+	if !ok {
+		if msg, ok = messages[Default][key]; !ok {
+			return key
+		}
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
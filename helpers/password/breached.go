@@ -0,0 +1,178 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/fnv"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BreachChecker - Tells whether a password is known from a data breach
+type BreachChecker interface {
+	Breached(password string) bool
+}
+
+// OpenBreachChecker - Opens an offline Have I Been Pwned dataset.
+// A directory is treated as HIBP range files (one file per 5 char SHA-1 prefix,
+// containing SUFFIX:COUNT lines), a regular file as a full HASH:COUNT list
+// that gets loaded into a bloom filter.
+func OpenBreachChecker(path string) (BreachChecker, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if st.IsDir() {
+		return PrefixDir(path), nil
+	}
+	return LoadBloom(path)
+}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// ===== Range files =====
+
+// PrefixDir - HIBP range files on disk, read on demand
+type PrefixDir string
+
+func (d PrefixDir) Breached(password string) bool {
+	h := sha1Hex(password)
+	prefix, suffix := h[:5], h[5:]
+
+	f, err := os.Open(filepath.Join(string(d), prefix))
+	if errors.Is(err, os.ErrNotExist) {
+		f, err = os.Open(filepath.Join(string(d), prefix+".txt"))
+	}
+	if err != nil {
+		return false
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), ":")
+		if strings.EqualFold(strings.TrimSpace(line), suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ===== Bloom filter =====
+
+// Bloom - In-memory bloom filter of breached SHA-1 hashes
+type Bloom struct {
+	bits []uint64
+	m    uint64
+	k    uint64
+}
+
+// bloomFalsePositiveRate - Chance of rejecting a password that isn't actually breached
+const bloomFalsePositiveRate = 0.001
+
+// NewBloom - Creates a bloom filter sized for n entries
+func NewBloom(n int) *Bloom {
+	if n < 1 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(bloomFalsePositiveRate) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &Bloom{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+// LoadBloom - Loads a HASH[:COUNT] file into a bloom filter
+func LoadBloom(path string) (*Bloom, error) {
+	lines, err := countLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	b := NewBloom(lines)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		h, _, _ := strings.Cut(strings.TrimSpace(sc.Text()), ":")
+		if len(h) != 40 {
+			continue
+		}
+		raw, err := hex.DecodeString(h)
+		if err != nil {
+			continue
+		}
+		b.Add(raw)
+	}
+	return b, sc.Err()
+}
+
+// Add - Adds a raw SHA-1 digest to the filter
+func (b *Bloom) Add(digest []byte) {
+	h1, h2 := bloomHashes(digest)
+	for i := uint64(0); i < b.k; i++ {
+		pos := (h1 + i*h2) % b.m
+		b.bits[pos/64] |= 1 << (pos % 64)
+	}
+}
+
+// Contains - Checks whether a raw SHA-1 digest might be in the filter
+func (b *Bloom) Contains(digest []byte) bool {
+	h1, h2 := bloomHashes(digest)
+	for i := uint64(0); i < b.k; i++ {
+		pos := (h1 + i*h2) % b.m
+		if b.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *Bloom) Breached(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	return b.Contains(sum[:])
+}
+
+// bloomHashes - Two independent hashes for double hashing.
+// The digest is already uniformly distributed, so the first one is just its prefix.
+func bloomHashes(digest []byte) (uint64, uint64) {
+	h1 := binary.BigEndian.Uint64(digest[:8])
+	f := fnv.New64a()
+	_, _ = f.Write(digest)
+	h2 := f.Sum64() | 1
+	return h1, h2
+}
+
+func countLines(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	n := 0
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		n++
+	}
+	return n, sc.Err()
+}
@@ -0,0 +1,68 @@
+package password
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrefixDir(t *testing.T) {
+	dir := t.TempDir()
+	h := sha1Hex("hunter2")
+	// HIBP range files name the prefix with or without .txt & list SUFFIX:COUNT
+	content := "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n" + strings.ToLower(h[5:]) + ":17\r\n"
+	if err := os.WriteFile(filepath.Join(dir, h[:5]+".txt"), []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	d := PrefixDir(dir)
+	if !d.Breached("hunter2") {
+		t.Error("listed password not reported as breached")
+	}
+	if d.Breached("hunter3") {
+		t.Error("unlisted password reported as breached")
+	}
+}
+
+func TestBloom(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	var lines []string
+	for i := 0; i < 1000; i++ {
+		sum := sha1.Sum([]byte(fmt.Sprintf("breached-%d", i)))
+		lines = append(lines, strings.ToUpper(hex.EncodeToString(sum[:]))+":3")
+	}
+	lines = append(lines, "not a hash", "")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	checker, err := OpenBreachChecker(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := checker.(*Bloom); !ok {
+		t.Fatalf("OpenBreachChecker(file) = %T, want *Bloom", checker)
+	}
+
+	// No false negatives
+	for i := 0; i < 1000; i++ {
+		if !checker.Breached(fmt.Sprintf("breached-%d", i)) {
+			t.Fatalf("breached-%d not found", i)
+		}
+	}
+
+	// False positives stay around bloomFalsePositiveRate
+	fp := 0
+	for i := 0; i < 10000; i++ {
+		if checker.Breached(fmt.Sprintf("fine-%d", i)) {
+			fp++
+		}
+	}
+	if fp > 50 {
+		t.Errorf("%d false positives in 10000, want about 10", fp)
+	}
+}
@@ -0,0 +1,113 @@
+package password
+
+import (
+	"app/helpers/pepper"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/alexedwards/argon2id"
+)
+
+var params = argon2id.DefaultParams
+
+// LoadParams - Reads the argon2id parameters from the environment, unset values keep the defaults
+func LoadParams() error {
+	p := *argon2id.DefaultParams
+
+	for _, f := range []struct {
+		key string
+		dst *uint32
+	}{
+		{"ARGON2_MEMORY", &p.Memory},
+		{"ARGON2_ITERATIONS", &p.Iterations},
+		{"ARGON2_SALT_LENGTH", &p.SaltLength},
+		{"ARGON2_KEY_LENGTH", &p.KeyLength},
+	} {
+		s := os.Getenv(f.key)
+		if s == "" {
+			continue
+		}
+		v, err := strconv.ParseUint(s, 10, 32)
+		if err != nil {
+			return err
+		}
+		*f.dst = uint32(v)
+	}
+
+	if s := os.Getenv("ARGON2_PARALLELISM"); s != "" {
+		v, err := strconv.ParseUint(s, 10, 8)
+		if err != nil {
+			return err
+		}
+		p.Parallelism = uint8(v)
+	}
+
+	// Zero iterations or parallelism panics inside argon2, catch bad values at startup instead of at the first login
+	for _, c := range []struct {
+		key      string
+		v        uint32
+		min, max uint32
+	}{
+		{"ARGON2_ITERATIONS", p.Iterations, 1, 100},
+		{"ARGON2_PARALLELISM", uint32(p.Parallelism), 1, 255},
+		{"ARGON2_MEMORY", p.Memory, 8 * uint32(p.Parallelism), 4 * 1024 * 1024},
+		{"ARGON2_SALT_LENGTH", p.SaltLength, 8, 1024},
+		{"ARGON2_KEY_LENGTH", p.KeyLength, 16, 1024},
+	} {
+		if c.v < c.min || c.v > c.max {
+			return fmt.Errorf("%s: must be between %d and %d, got %d", c.key, c.min, c.max, c.v)
+		}
+	}
+
+	params = &p
+	return nil
+}
+
+// Stored - A password hash as stored in the DB
+type Stored struct {
+	Algo  string // Hashing algorithm (see Algo* constants)
+	KeyID string // Pepper key ID, "" if the hash isn't peppered
+	Hash  string
+}
+
+// Hash - Hashes a password with the configured argon2id parameters & the current pepper
+func Hash(password string) (Stored, error) {
+	keyID := pepper.CurrentID()
+	peppered, err := pepper.Password(keyID, password)
+	if err != nil {
+		return Stored{}, err
+	}
+	h, err := argon2id.CreateHash(peppered, params)
+	if err != nil {
+		return Stored{}, err
+	}
+	return Stored{Algo: AlgoArgon2id, KeyID: keyID, Hash: h}, nil
+}
+
+// Verify - Compares a password against a stored hash
+func Verify(password string, s Stored) (bool, error) {
+	peppered, err := pepper.Password(s.KeyID, password)
+	if err != nil {
+		return false, err
+	}
+	if s.Algo != AlgoArgon2id {
+		return verifyLegacy(peppered, s.Algo, s.Hash)
+	}
+	return argon2id.ComparePasswordAndHash(peppered, s.Hash)
+}
+
+// NeedsRehash - Checks if a stored hash is a legacy format, uses an old pepper
+// or was created with weaker parameters than the configured ones
+func NeedsRehash(s Stored) bool {
+	if s.Algo != AlgoArgon2id || s.KeyID != pepper.CurrentID() {
+		return true
+	}
+	p, _, _, err := argon2id.DecodeHash(s.Hash)
+	if err != nil {
+		return true
+	}
+	return p.Memory < params.Memory ||
+		p.Iterations < params.Iterations ||
+		p.KeyLength < params.KeyLength
+}
@@ -0,0 +1,31 @@
+package password
+
+import "testing"
+
+func TestLoadParamsRejectsOutOfRange(t *testing.T) {
+	for _, tc := range []struct {
+		key, value string
+		ok         bool
+	}{
+		{"ARGON2_ITERATIONS", "0", false},
+		{"ARGON2_ITERATIONS", "3", true},
+		{"ARGON2_PARALLELISM", "0", false},
+		{"ARGON2_PARALLELISM", "256", false},
+		{"ARGON2_PARALLELISM", "4", true},
+		{"ARGON2_MEMORY", "0", false},
+		{"ARGON2_MEMORY", "65536", true},
+		{"ARGON2_SALT_LENGTH", "0", false},
+		{"ARGON2_KEY_LENGTH", "0", false},
+		{"ARGON2_KEY_LENGTH", "32", true},
+	} {
+		t.Run(tc.key+"="+tc.value, func(t *testing.T) {
+			old := params
+			defer func() { params = old }()
+
+			t.Setenv(tc.key, tc.value)
+			if err := LoadParams(); (err == nil) != tc.ok {
+				t.Fatalf("LoadParams() = %v, want ok=%v", err, tc.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package password
+
+import (
+	"app/helpers/pepper"
+	"database/sql"
+	"errors"
+)
+
+// Reused - Checks the new password against the current hash & the user's password history.
+// HistorySize counts the current password, so HistorySize-1 previous ones are looked at.
+func Reused(db *sql.DB, userID int64, password string, current Stored) (bool, error) {
+	if policy.HistorySize <= 0 {
+		return false, nil
+	}
+
+	var history []Stored
+	rows, err := db.Query(`
+		SELECT password_algo, password_key_id, password_hash
+		FROM password_history
+		WHERE user_id = $1
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2
+		`, userID, previousKept(policy.HistorySize))
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+	for rows.Next() {
+		var h Stored
+		if err = rows.Scan(&h.Algo, &h.KeyID, &h.Hash); err != nil {
+			return false, err
+		}
+		history = append(history, h)
+	}
+	if err = rows.Err(); err != nil {
+		return false, err
+	}
+
+	return reusedAmong(password, current, history, policy.HistorySize)
+}
+
+// previousKept - How many replaced passwords a history of size passwords keeps next to the current one
+func previousKept(size int) int {
+	return max(size-1, 0)
+}
+
+// reusedAmong - Compares the password with the current hash & the newest previousKept(size) of history (newest first)
+func reusedAmong(password string, current Stored, history []Stored, size int) (bool, error) {
+	if size <= 0 {
+		return false, nil
+	}
+	hashes := append([]Stored{current}, history[:min(len(history), previousKept(size))]...)
+
+	for _, h := range hashes {
+		match, err := Verify(password, h)
+		if errors.Is(err, pepper.ErrUnknownKey) {
+			continue // Peppered with a retired key, can't be compared anymore
+		}
+		if err != nil {
+			return false, err
+		}
+		if match {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Remember - Stores a replaced password hash & prunes entries beyond the history size,
+// in the transaction that updates the password
+func Remember(tx *sql.Tx, userID int64, old Stored) error {
+	if previousKept(policy.HistorySize) == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO password_history (user_id, password_algo, password_key_id, password_hash)
+		VALUES ($1, $2, $3, $4)
+		`, userID, old.Algo, old.KeyID, old.Hash)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM password_history
+		WHERE user_id = $1
+		  AND id NOT IN (
+			SELECT id FROM password_history
+			WHERE user_id = $1
+			ORDER BY created_at DESC, id DESC
+			LIMIT $2
+		  )
+		`, userID, previousKept(policy.HistorySize))
+	return err
+}
@@ -0,0 +1,54 @@
+package password
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"testing"
+)
+
+// cheapHash - A fast legacy hash, argon2id would make the test slow
+func cheapHash(t *testing.T, password string) Stored {
+	t.Helper()
+	dk, err := pbkdf2.Key(sha256.New, password, []byte("salt"), 1, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Stored{Algo: AlgoPBKDF2SHA256, Hash: fmt.Sprintf("pbkdf2_sha256$1$salt$%s", base64.StdEncoding.EncodeToString(dk))}
+}
+
+// A history size of N blocks exactly the last N passwords, the current one included
+func TestReusedAmongBoundary(t *testing.T) {
+	// Passwords p1 ... p7, p7 is the current one, history is newest first & may hold more rows than needed
+	current := cheapHash(t, "p7")
+	var history []Stored
+	for i := 6; i >= 1; i-- {
+		history = append(history, cheapHash(t, fmt.Sprintf("p%d", i)))
+	}
+
+	for _, tc := range []struct {
+		size    int
+		blocked []string
+		allowed []string
+	}{
+		{0, nil, []string{"p7", "p6", "p1"}},
+		{1, []string{"p7"}, []string{"p6"}},
+		{3, []string{"p7", "p6", "p5"}, []string{"p4", "p1"}},
+		{5, []string{"p7", "p6", "p5", "p4", "p3"}, []string{"p2", "p1"}},
+	} {
+		for _, pw := range tc.blocked {
+			if reused, err := reusedAmong(pw, current, history, tc.size); err != nil || !reused {
+				t.Errorf("size %d: %s = %v, %v, want reused", tc.size, pw, reused, err)
+			}
+		}
+		for _, pw := range tc.allowed {
+			if reused, err := reusedAmong(pw, current, history, tc.size); err != nil || reused {
+				t.Errorf("size %d: %s = %v, %v, want allowed", tc.size, pw, reused, err)
+			}
+		}
+		if tc.size > 0 && previousKept(tc.size) != tc.size-1 {
+			t.Errorf("previousKept(%d) = %d, want %d", tc.size, previousKept(tc.size), tc.size-1)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package password
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"strconv"
+	"strings"
+
+	"github.com/alexedwards/argon2id"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Hash algorithms stored in users.password_algo
+const (
+	AlgoArgon2id     = "argon2id"
+	AlgoBcrypt       = "bcrypt"        // $2a$/$2b$/$2y$ (Rails, Django "bcrypt$")
+	AlgoBcryptSHA256 = "bcrypt_sha256" // Django: bcrypt over hex(sha256(password))
+	AlgoPBKDF2SHA256 = "pbkdf2_sha256" // Django: pbkdf2_sha256$iter$salt$b64hash
+	AlgoPBKDF2SHA1   = "pbkdf2_sha1"   // Django: pbkdf2_sha1$iter$salt$b64hash
+	AlgoScrypt       = "scrypt"        // Django: scrypt$n$salt$r$p$b64hash
+)
+
+var (
+	ErrUnknownHash = errors.New("password: unrecognised hash format")
+	ErrHashCost    = errors.New("password: hash cost parameters out of range")
+)
+
+// Bounds on the cost parameters of imported hashes. They come from another system's database &
+// are fed straight into the KDF at login, so a crafted row mustn't be able to pin a CPU or exhaust memory.
+const (
+	maxArgon2Memory     = 1 << 20 // KiB
+	maxArgon2Iterations = 100
+	maxArgon2Threads    = 16
+	maxPBKDF2Iterations = 10_000_000
+	maxScryptN          = 1 << 20
+	maxScryptR          = 32
+	maxScryptP          = 16
+	maxScryptMemory     = 1 << 30 // 128 * N * r bytes
+	maxDerivedKeyLen    = 64
+)
+
+// Identify - Detects the algorithm of a foreign password hash & normalises it for storage
+func Identify(h string) (algo, normalized string, err error) {
+	switch {
+	case strings.HasPrefix(h, "$argon2id$"), strings.HasPrefix(h, "argon2$argon2id$"):
+		// Django prefixes the standard encoding with its hasher name
+		h = strings.TrimPrefix(h, "argon2")
+		if err := checkArgon2(h); err != nil {
+			return "", "", err
+		}
+		return AlgoArgon2id, h, nil
+	case isBcrypt(h):
+		return AlgoBcrypt, h, nil
+	case strings.HasPrefix(h, "bcrypt$") && isBcrypt(strings.TrimPrefix(h, "bcrypt$")):
+		return AlgoBcrypt, strings.TrimPrefix(h, "bcrypt$"), nil
+	case strings.HasPrefix(h, "bcrypt_sha256$") && isBcrypt(strings.TrimPrefix(h, "bcrypt_sha256$")):
+		return AlgoBcryptSHA256, strings.TrimPrefix(h, "bcrypt_sha256$"), nil
+	case strings.HasPrefix(h, AlgoPBKDF2SHA256+"$"), strings.HasPrefix(h, AlgoPBKDF2SHA1+"$"):
+		if _, _, _, err := parsePBKDF2(h); err != nil {
+			return "", "", err
+		}
+		return h[:strings.IndexByte(h, '$')], h, nil
+	case strings.HasPrefix(h, AlgoScrypt+"$"):
+		if _, err := parseScrypt(h); err != nil {
+			return "", "", err
+		}
+		return AlgoScrypt, h, nil
+	}
+	return "", "", ErrUnknownHash
+}
+
+func isBcrypt(h string) bool {
+	return len(h) == 60 && (strings.HasPrefix(h, "$2a$") || strings.HasPrefix(h, "$2b$") || strings.HasPrefix(h, "$2y$"))
+}
+
+// verifyLegacy - Compares a password against a hash imported from another system
+func verifyLegacy(password, algo, h string) (bool, error) {
+	switch algo {
+	case AlgoBcrypt:
+		return compareBcrypt([]byte(password), h)
+	case AlgoBcryptSHA256:
+		sum := sha256.Sum256([]byte(password))
+		return compareBcrypt([]byte(hex.EncodeToString(sum[:])), h)
+	case AlgoPBKDF2SHA256:
+		return comparePBKDF2(password, h, sha256.New)
+	case AlgoPBKDF2SHA1:
+		return comparePBKDF2(password, h, sha1.New)
+	case AlgoScrypt:
+		return compareScrypt(password, h)
+	}
+	return false, ErrUnknownHash
+}
+
+func compareBcrypt(password []byte, h string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(h), password)
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// checkArgon2 - Checks the memory, time & parallelism of an imported argon2id hash
+func checkArgon2(h string) error {
+	p, _, key, err := argon2id.DecodeHash(h)
+	if err != nil {
+		return ErrUnknownHash
+	}
+	if p.Memory < 1 || p.Memory > maxArgon2Memory || p.Iterations < 1 || p.Iterations > maxArgon2Iterations ||
+		p.Parallelism < 1 || p.Parallelism > maxArgon2Threads || len(key) == 0 || len(key) > maxDerivedKeyLen {
+		return ErrHashCost
+	}
+	return nil
+}
+
+// parsePBKDF2 - Splits pbkdf2_<digest>$iter$salt$b64hash & checks the iteration count
+func parsePBKDF2(h string) (iter int, salt string, want []byte, err error) {
+	parts := strings.Split(h, "$")
+	if len(parts) != 4 {
+		return 0, "", nil, ErrUnknownHash
+	}
+	iter, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, "", nil, ErrUnknownHash
+	}
+	want, err = base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return 0, "", nil, ErrUnknownHash
+	}
+	if iter < 1 || iter > maxPBKDF2Iterations || len(want) == 0 || len(want) > maxDerivedKeyLen {
+		return 0, "", nil, ErrHashCost
+	}
+	return iter, parts[2], want, nil
+}
+
+func comparePBKDF2(password, h string, fn func() hash.Hash) (bool, error) {
+	iter, salt, want, err := parsePBKDF2(h)
+	if err != nil {
+		return false, err
+	}
+
+	got, err := pbkdf2.Key(fn, password, []byte(salt), iter, len(want))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(got, want) == 1, nil
+}
+
+type scryptParams struct {
+	n, r, p int
+	salt    string
+	want    []byte
+}
+
+// parseScrypt - Splits scrypt$n$salt$r$p$b64hash & checks N, r & p
+func parseScrypt(h string) (scryptParams, error) {
+	parts := strings.Split(h, "$")
+	if len(parts) != 6 {
+		return scryptParams{}, ErrUnknownHash
+	}
+	n, err1 := strconv.Atoi(parts[1])
+	r, err2 := strconv.Atoi(parts[3])
+	p, err3 := strconv.Atoi(parts[4])
+	want, err4 := base64.StdEncoding.DecodeString(parts[5])
+	if err := errors.Join(err1, err2, err3, err4); err != nil {
+		return scryptParams{}, ErrUnknownHash
+	}
+
+	// N must be a power of two above 1
+	if n < 2 || n > maxScryptN || n&(n-1) != 0 ||
+		r < 1 || r > maxScryptR || p < 1 || p > maxScryptP ||
+		128*n*r > maxScryptMemory || len(want) == 0 || len(want) > maxDerivedKeyLen {
+		return scryptParams{}, ErrHashCost
+	}
+	return scryptParams{n: n, r: r, p: p, salt: parts[2], want: want}, nil
+}
+
+func compareScrypt(password, h string) (bool, error) {
+	sp, err := parseScrypt(h)
+	if err != nil {
+		return false, err
+	}
+
+	got, err := scrypt.Key([]byte(password), []byte(sp.salt), sp.n, sp.r, sp.p, len(sp.want))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(got, sp.want) == 1, nil
+}
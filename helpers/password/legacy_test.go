@@ -0,0 +1,111 @@
+package password
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Imported users keep whatever password their old system allowed, including ones
+// shorter than the policy. Their hashes must still verify & be flagged for the argon2id upgrade.
+func TestShortImportedPasswordVerifies(t *testing.T) {
+	const short = "abc"
+
+	bc, err := bcrypt.GenerateFromPassword([]byte(short), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dk, err := pbkdf2.Key(sha256.New, short, []byte("salt"), 1000, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, imported := range []string{
+		string(bc),
+		"bcrypt$" + string(bc),
+		fmt.Sprintf("pbkdf2_sha256$1000$salt$%s", base64.StdEncoding.EncodeToString(dk)),
+	} {
+		algo, h, err := Identify(imported)
+		if err != nil {
+			t.Fatalf("Identify(%q): %v", imported, err)
+		}
+		stored := Stored{Algo: algo, Hash: h}
+
+		match, err := Verify(short, stored)
+		if err != nil || !match {
+			t.Fatalf("%s: Verify = %v, %v, want a match", algo, match, err)
+		}
+		if match, _ = Verify(short+"d", stored); match {
+			t.Fatalf("%s: a wrong password matched", algo)
+		}
+		if !NeedsRehash(stored) {
+			t.Fatalf("%s: legacy hash not flagged for rehash", algo)
+		}
+
+		// The upgrade at login hashes the same short password
+		upgraded, err := Hash(short)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if match, err = Verify(short, upgraded); err != nil || !match {
+			t.Fatalf("%s: upgraded hash doesn't verify: %v, %v", algo, match, err)
+		}
+	}
+}
+
+// Cost parameters come from the imported row, anything that could tie up the CPU
+// or exhaust memory at login is refused.
+func TestIdentifyRejectsExpensiveHashes(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	rawKey := base64.RawStdEncoding.EncodeToString(make([]byte, 32))
+	salt := base64.RawStdEncoding.EncodeToString([]byte("saltsaltsalt"))
+
+	for _, tc := range []struct {
+		hash string
+		err  error
+	}{
+		{"pbkdf2_sha256$1000000$salt$" + key, nil},
+		{"pbkdf2_sha1$260000$salt$" + key, nil},
+		{"scrypt$16384$salt$8$1$" + key, nil},
+		{"$argon2id$v=19$m=65536,t=3,p=2$" + salt + "$" + rawKey, nil},
+		{"argon2$argon2id$v=19$m=102400,t=2,p=8$" + salt + "$" + rawKey, nil},
+
+		{"pbkdf2_sha256$2000000000$salt$" + key, ErrHashCost},
+		{"pbkdf2_sha256$0$salt$" + key, ErrHashCost},
+		{"pbkdf2_sha1$-5$salt$" + key, ErrHashCost},
+		{"pbkdf2_sha256$1000$salt$" + base64.StdEncoding.EncodeToString(make([]byte, 4096)), ErrHashCost},
+		{"pbkdf2_sha256$1000$salt", ErrUnknownHash},
+		{"pbkdf2_sha256$many$salt$" + key, ErrUnknownHash},
+		{"scrypt$1073741824$salt$8$1$" + key, ErrHashCost},
+		{"scrypt$16383$salt$8$1$" + key, ErrHashCost},
+		{"scrypt$1048576$salt$32$1$" + key, ErrHashCost},
+		{"scrypt$16384$salt$8$1000$" + key, ErrHashCost},
+		{"scrypt$16384$salt$0$1$" + key, ErrHashCost},
+		{"$argon2id$v=19$m=4194304,t=3,p=2$" + salt + "$" + rawKey, ErrHashCost},
+		{"$argon2id$v=19$m=65536,t=100000,p=2$" + salt + "$" + rawKey, ErrHashCost},
+		{"$argon2id$v=19$m=65536,t=3,p=255$" + salt + "$" + rawKey, ErrHashCost},
+	} {
+		_, _, err := Identify(tc.hash)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("Identify(%.40q) = %v, want %v", tc.hash, err, tc.err)
+		}
+	}
+}
+
+// Rows already in the DB are checked again before the KDF runs
+func TestVerifyRefusesExpensiveStoredHash(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	for _, stored := range []Stored{
+		{Algo: AlgoPBKDF2SHA256, Hash: "pbkdf2_sha256$2000000000$salt$" + key},
+		{Algo: AlgoScrypt, Hash: "scrypt$1073741824$salt$8$1$" + key},
+	} {
+		if match, err := Verify("password", stored); match || !errors.Is(err, ErrHashCost) {
+			t.Errorf("%s: Verify = %v, %v, want ErrHashCost", stored.Algo, match, err)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package password
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Policy - Rules a new password has to satisfy
+type Policy struct {
+	MinLength int
+	MaxLength int
+	MinScore  int
+	Banned    map[string]struct{}
+	Breached  BreachChecker
+
+	// HistorySize - Recent passwords, the current one included, that can't be reused (0 disables the check)
+	HistorySize int
+}
+
+// Inputs - User data a password must not contain
+type Inputs struct {
+	Email string
+	Name  string
+}
+
+// Violation - A single failed policy rule.
+// Message is filled in by the handler from the i18n catalog (password.<rule>), in the client's language.
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+const (
+	RuleMinLength = "min_length"
+	RuleMaxLength = "max_length"
+	RuleStrength  = "strength"
+	RulePersonal  = "personal_info"
+	RuleBanned    = "banned"
+	RuleBreached  = "breached"
+	RuleReused    = "reused"
+)
+
+var policy = DefaultPolicy()
+
+// DefaultPolicy - The policy used when nothing is configured
+func DefaultPolicy() *Policy {
+	banned := make(map[string]struct{}, len(commonPasswords))
+	for _, p := range commonPasswords {
+		banned[p] = struct{}{}
+	}
+	return &Policy{
+		MinLength: 8,
+		MaxLength: 128,
+		MinScore:  2,
+		Banned:    banned,
+
+		HistorySize: 5,
+	}
+}
+
+// LoadPolicy - Builds the policy from the environment & makes it the active one
+func LoadPolicy() error {
+	p := DefaultPolicy()
+
+	if v, err := envInt("PASSWORD_MIN_LENGTH"); err != nil {
+		return err
+	} else if v > 0 {
+		p.MinLength = v
+	}
+	if v, err := envInt("PASSWORD_MAX_LENGTH"); err != nil {
+		return err
+	} else if v > 0 {
+		p.MaxLength = v
+	}
+	if s := os.Getenv("PASSWORD_MIN_SCORE"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		p.MinScore = v
+	}
+	if s := os.Getenv("PASSWORD_HISTORY_SIZE"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		p.HistorySize = v
+	}
+
+	// Catch a policy no password can satisfy at startup instead of at the first registration
+	for _, c := range []struct {
+		key      string
+		v        int
+		min, max int
+	}{
+		{"PASSWORD_MIN_LENGTH", p.MinLength, 1, p.MaxLength},
+		{"PASSWORD_MAX_LENGTH", p.MaxLength, p.MinLength, 4096},
+		{"PASSWORD_MIN_SCORE", p.MinScore, 0, 4},
+		{"PASSWORD_HISTORY_SIZE", p.HistorySize, 0, 100},
+	} {
+		if c.v < c.min || c.v > c.max {
+			return fmt.Errorf("%s: must be between %d and %d, got %d", c.key, c.min, c.max, c.v)
+		}
+	}
+
+	// Extra banned passwords (one per line)
+	if path := os.Getenv("PASSWORD_BANNED_FILE"); path != "" {
+		if err := loadBanned(path, p.Banned); err != nil {
+			return err
+		}
+	}
+
+	// Offline breached password list
+	if path := os.Getenv("PASSWORD_BREACHED_PATH"); path != "" {
+		checker, err := OpenBreachChecker(path)
+		if err != nil {
+			return err
+		}
+		p.Breached = checker
+	}
+
+	policy = p
+	return nil
+}
+
+// Lengths - The active policy's minimum & maximum length
+func Lengths() (minLength int, maxLength int) {
+	return policy.MinLength, policy.MaxLength
+}
+
+// Check - Validates a password against the active policy
+func Check(password string, in Inputs) []Violation {
+	return policy.Check(password, in)
+}
+
+// Check - Validates a password against the policy, returning every rule it fails
+func (p *Policy) Check(password string, in Inputs) []Violation {
+	var v []Violation
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		v = append(v, Violation{Rule: RuleMinLength})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		v = append(v, Violation{Rule: RuleMaxLength})
+		// Don't run the expensive checks on oversized input
+		return v
+	}
+
+	lower := strings.ToLower(password)
+	if containsPersonalInfo(lower, in) {
+		v = append(v, Violation{Rule: RulePersonal})
+	}
+
+	if _, ok := p.Banned[lower]; ok {
+		v = append(v, Violation{Rule: RuleBanned})
+	}
+
+	if Score(password, in) < p.MinScore {
+		v = append(v, Violation{Rule: RuleStrength})
+	}
+
+	if p.Breached != nil && p.Breached.Breached(password) {
+		v = append(v, Violation{Rule: RuleBreached})
+	}
+
+	return v
+}
+
+// ReusedViolation - The violation returned when a password is in the user's history
+func ReusedViolation() Violation {
+	return Violation{Rule: RuleReused}
+}
+
+// containsPersonalInfo - Checks the (lowercased) password for the user's email & name parts
+func containsPersonalInfo(lower string, in Inputs) bool {
+	for _, part := range personalTokens(in) {
+		if strings.Contains(lower, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// personalTokens - Lowercased fragments of the user's email & name worth checking for
+func personalTokens(in Inputs) []string {
+	var parts []string
+	email := strings.ToLower(strings.TrimSpace(in.Email))
+	if email != "" {
+		parts = append(parts, email)
+		if local, _, ok := strings.Cut(email, "@"); ok {
+			parts = append(parts, local)
+		}
+	}
+	parts = append(parts, strings.Fields(strings.ToLower(in.Name))...)
+
+	// Ignore tiny fragments, they'd reject too many good passwords
+	var out []string
+	for _, p := range parts {
+		if utf8.RuneCountInString(p) >= 3 {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func loadBanned(path string, banned map[string]struct{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.ToLower(strings.TrimSpace(sc.Text()))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		banned[line] = struct{}{}
+	}
+	return sc.Err()
+}
+
+func envInt(key string) (int, error) {
+	s := os.Getenv(key)
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
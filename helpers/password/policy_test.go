@@ -0,0 +1,106 @@
+package password
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+type fakeBreached []string
+
+func (f fakeBreached) Breached(password string) bool {
+	return slices.Contains(f, password)
+}
+
+func TestPolicyCheck(t *testing.T) {
+	p := DefaultPolicy()
+	p.MaxLength = 64
+	p.Breached = fakeBreached{"Tr0ub4dor&3-leaked"}
+	in := Inputs{Email: "jane.doe@example.com", Name: "Jane Doe"}
+
+	for _, tc := range []struct {
+		password string
+		want     []string
+	}{
+		{"vivid-Lantern-orbit-42", nil},
+		{"short", []string{RuleMinLength}},
+		{strings.Repeat("x", 65), []string{RuleMaxLength}},
+		{"password", []string{RuleBanned, RuleStrength}},
+		{"jane.doe@example.com!", []string{RulePersonal, RuleStrength}},
+		{"Doe-rocket-mansion-91", []string{RulePersonal}},
+		{"Tr0ub4dor&3-leaked", []string{RuleBreached}},
+		{"aaaaaaaaaaaa", []string{RuleStrength}},
+		{"qwertyuiop123", []string{RuleStrength}},
+		{"ab", []string{RuleMinLength, RuleStrength}},
+	} {
+		t.Run(tc.password, func(t *testing.T) {
+			var got []string
+			for _, v := range p.Check(tc.password, in) {
+				got = append(got, v.Rule)
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Fatalf("Check(%q) = %v, want %v", tc.password, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestScore(t *testing.T) {
+	in := Inputs{Email: "jane@example.com", Name: "Jane"}
+	for _, tc := range []struct {
+		password string
+		min, max int
+	}{
+		{"", 0, 0},
+		{"password", 0, 0},
+		{"123456789", 0, 1},
+		{"abcdefgh", 0, 1},
+		{"jane1234", 0, 1},
+		{"Tk9#vQ2!", 3, 4},
+		{"vivid-Lantern-orbit-42", 4, 4},
+	} {
+		if got := Score(tc.password, in); got < tc.min || got > tc.max {
+			t.Errorf("Score(%q) = %d, want %d-%d", tc.password, got, tc.min, tc.max)
+		}
+	}
+}
+
+func TestLoadPolicyRejectsOutOfRange(t *testing.T) {
+	for _, tc := range []struct {
+		env map[string]string
+		ok  bool
+	}{
+		{map[string]string{"PASSWORD_MIN_SCORE": "0"}, true},
+		{map[string]string{"PASSWORD_MIN_SCORE": "4"}, true},
+		{map[string]string{"PASSWORD_MIN_SCORE": "5"}, false},
+		{map[string]string{"PASSWORD_MIN_SCORE": "-1"}, false},
+		{map[string]string{"PASSWORD_MIN_LENGTH": "12", "PASSWORD_MAX_LENGTH": "64"}, true},
+		{map[string]string{"PASSWORD_MIN_LENGTH": "64", "PASSWORD_MAX_LENGTH": "12"}, false},
+		{map[string]string{"PASSWORD_MIN_LENGTH": "200"}, false},
+		{map[string]string{"PASSWORD_HISTORY_SIZE": "0"}, true},
+		{map[string]string{"PASSWORD_HISTORY_SIZE": "-3"}, false},
+		{map[string]string{"PASSWORD_MIN_LENGTH": "eight"}, false},
+	} {
+		t.Run(fmtEnv(tc.env), func(t *testing.T) {
+			old := policy
+			defer func() { policy = old }()
+
+			for _, k := range []string{"PASSWORD_MIN_LENGTH", "PASSWORD_MAX_LENGTH", "PASSWORD_MIN_SCORE",
+				"PASSWORD_HISTORY_SIZE", "PASSWORD_BANNED_FILE", "PASSWORD_BREACHED_PATH"} {
+				t.Setenv(k, tc.env[k])
+			}
+			if err := LoadPolicy(); (err == nil) != tc.ok {
+				t.Fatalf("LoadPolicy() = %v, want ok=%v", err, tc.ok)
+			}
+		})
+	}
+}
+
+func fmtEnv(env map[string]string) string {
+	var parts []string
+	for k, v := range env {
+		parts = append(parts, k+"="+v)
+	}
+	slices.Sort(parts)
+	return strings.Join(parts, ",")
+}
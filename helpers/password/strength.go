@@ -0,0 +1,211 @@
+package password
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Score - Estimates how hard a password is to guess, from 0 (trivial) to 4 (strong).
+// It follows the zxcvbn idea: split the password into the cheapest known patterns
+// (dictionary words, repeats, sequences, keyboard walks) and sum their guess counts.
+func Score(password string, in Inputs) int {
+	guesses := log10Guesses(password, in)
+	switch {
+	case guesses < 3:
+		return 0
+	case guesses < 6:
+		return 1
+	case guesses < 8:
+		return 2
+	case guesses < 10:
+		return 3
+	default:
+		return 4
+	}
+}
+
+var keyboardRows = []string{
+	"`1234567890-=",
+	"qwertyuiop[]\\",
+	"asdfghjkl;'",
+	"zxcvbnm,./",
+	"qwertzuiop",
+	"yxcvbnm",
+	"azertyuiop",
+	"wxcvbn",
+}
+
+// log10Guesses - log10 of the estimated number of guesses needed to crack the password
+func log10Guesses(password string, in Inputs) float64 {
+	runes := []rune(password)
+	lower := []rune(strings.ToLower(password))
+	n := len(runes)
+	if n == 0 {
+		return 0
+	}
+
+	dict := append(append([]string{}, personalTokens(in)...), commonPasswords...)
+	bruteforce := math.Log10(float64(charsetSize(runes)))
+
+	// best[i] = cheapest guess estimate for the first i runes
+	best := make([]float64, n+1)
+	for i := 1; i <= n; i++ {
+		best[i] = math.Inf(1)
+	}
+	for i := 0; i < n; i++ {
+		if math.IsInf(best[i], 1) {
+			continue
+		}
+
+		// A single brute-forced character
+		if g := best[i] + bruteforce; g < best[i+1] {
+			best[i+1] = g
+		}
+
+		// Patterns starting at i
+		for j := i + 3; j <= n; j++ {
+			cost, ok := patternCost(runes[i:j], lower[i:j], dict)
+			if !ok {
+				continue
+			}
+			if g := best[i] + cost; g < best[j] {
+				best[j] = g
+			}
+		}
+	}
+
+	return best[n]
+}
+
+// patternCost - log10 guesses for a known low-entropy pattern, ok=false if it isn't one
+func patternCost(orig, lower []rune, dict []string) (float64, bool) {
+	s := string(lower)
+	length := float64(len(lower))
+
+	// Dictionary words, ranked by position
+	for rank, word := range dict {
+		if word == s {
+			return math.Log10(float64(rank+1)) + caseVariations(orig), true
+		}
+	}
+
+	// Repeated character (aaaa)
+	if isRepeat(lower) {
+		return math.Log10(float64(charsetSize(orig)) * length), true
+	}
+
+	// Sequences (abcd, 4321)
+	if isSequence(lower) {
+		return math.Log10(26 * length * 2), true
+	}
+
+	// Keyboard walks (qwerty, asdf)
+	for _, row := range keyboardRows {
+		if strings.Contains(row, s) || strings.Contains(reverse(row), s) {
+			return math.Log10(float64(len(keyboardRows)) * length * 2), true
+		}
+	}
+
+	return 0, false
+}
+
+func isRepeat(r []rune) bool {
+	for i := 1; i < len(r); i++ {
+		if r[i] != r[0] {
+			return false
+		}
+	}
+	return true
+}
+
+func isSequence(r []rune) bool {
+	delta := r[1] - r[0]
+	if delta != 1 && delta != -1 {
+		return false
+	}
+	for i := 2; i < len(r); i++ {
+		if r[i]-r[i-1] != delta {
+			return false
+		}
+	}
+	return true
+}
+
+// caseVariations - log10 of the extra guesses needed for capitalisation of a word
+func caseVariations(r []rune) float64 {
+	upper := 0
+	for _, c := range r {
+		if unicode.IsUpper(c) {
+			upper++
+		}
+	}
+	switch {
+	case upper == 0:
+		return 0
+	case upper == len(r) || (upper == 1 && unicode.IsUpper(r[0])):
+		return math.Log10(2)
+	default:
+		return math.Log10(float64(len(r)) * float64(upper))
+	}
+}
+
+func charsetSize(r []rune) int {
+	var lower, upper, digit, symbol, other bool
+	for _, c := range r {
+		switch {
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= '0' && c <= '9':
+			digit = true
+		case c < 128:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	size := 0
+	if lower {
+		size += 26
+	}
+	if upper {
+		size += 26
+	}
+	if digit {
+		size += 10
+	}
+	if symbol {
+		size += 33
+	}
+	if other {
+		size += 100
+	}
+	return size
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
+
+// commonPasswords - Most common leaked passwords, most common first
+var commonPasswords = []string{
+	"123456", "password", "123456789", "12345678", "12345", "qwerty", "1234567", "111111",
+	"1234567890", "123123", "abc123", "1234", "password1", "iloveyou", "1q2w3e4r", "000000",
+	"qwerty123", "zaq12wsx", "dragon", "sunshine", "princess", "letmein", "654321", "monkey",
+	"27653", "1qaz2wsx", "123321", "qwertyuiop", "superman", "asdfghjkl", "welcome", "admin",
+	"football", "baseball", "master", "shadow", "michael", "jennifer", "trustno1", "hello",
+	"freedom", "whatever", "starwars", "passw0rd", "login", "access", "flower", "hottie",
+	"loveme", "zxcvbnm", "charlie", "donald", "batman", "mustang", "secret", "summer",
+	"winter", "spring", "autumn", "changeme", "computer", "internet", "soccer", "hockey",
+	"killer", "pepper", "ginger", "cheese", "cookie", "banana", "chocolate", "purple",
+	"orange", "yellow", "silver", "golden", "diamond", "love", "lovely", "angel", "jesus",
+	"google", "pokemon", "naruto", "minecraft", "matrix", "ninja", "thomas", "jordan",
+	"hunter", "ranger", "buster", "tigger", "robert", "daniel", "andrew", "joshua",
+	"qazwsx", "asdf", "test", "test123", "guest", "default", "root", "user", "pass",
+}
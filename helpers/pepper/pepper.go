@@ -0,0 +1,143 @@
+package pepper
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Key - A server-side secret, identified by the ID stored next to each hash
+type Key struct {
+	ID       string
+	token    []byte
+	password []byte
+}
+
+// keys - Known keys, the first one is used for new hashes
+var keys []Key
+
+// Load - Reads the keys from PEPPER_KEYS ("id:base64secret,..." newest first).
+// Without keys, tokens are plain SHA-256 & passwords aren't peppered (key ID "").
+func Load() error {
+	raw := strings.TrimSpace(os.Getenv("PEPPER_KEYS"))
+	if raw == "" {
+		keys = nil
+		return nil
+	}
+
+	var loaded []Key
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(raw, ",") {
+		id, secret, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" || len(id) > 32 {
+			return fmt.Errorf("PEPPER_KEYS: entries must look like id:base64secret (id up to 32 chars)")
+		}
+		if seen[id] {
+			return fmt.Errorf("PEPPER_KEYS: duplicate key id %q", id)
+		}
+		seen[id] = true
+
+		b, err := base64.StdEncoding.DecodeString(secret)
+		if err != nil {
+			return fmt.Errorf("PEPPER_KEYS: key %q is not valid base64", id)
+		}
+		if len(b) < 32 {
+			return fmt.Errorf("PEPPER_KEYS: key %q must be at least 32 bytes", id)
+		}
+
+		// Separate subkeys so token & password hashes never share a key
+		loaded = append(loaded, Key{
+			ID:       id,
+			token:    mac(b, []byte("token")),
+			password: mac(b, []byte("password")),
+		})
+	}
+
+	keys = loaded
+	return nil
+}
+
+// CurrentID - ID of the key new hashes are created with ("" when no keys are configured)
+func CurrentID() string {
+	if len(keys) == 0 {
+		return ""
+	}
+	return keys[0].ID
+}
+
+// TokenHash - Hashes a token with the current key (HMAC-SHA256, or plain SHA-256 without keys)
+func TokenHash(token string) []byte {
+	if len(keys) == 0 {
+		sum := sha256.Sum256([]byte(token))
+		return sum[:]
+	}
+	return mac(keys[0].token, []byte(token))
+}
+
+// TokenHashes - Hashes of a token under every known key, current key first, each paired with the
+// key ID its row must store. Used to find rows that were hashed before the last rotation;
+// the plain SHA-256 only matches rows hashed without a key (key ID "").
+func TokenHashes(token string) (ids []string, hashes [][]byte) {
+	for _, k := range keys {
+		ids = append(ids, k.ID)
+		hashes = append(hashes, mac(k.token, []byte(token)))
+	}
+	sum := sha256.Sum256([]byte(token))
+	return append(ids, ""), append(hashes, sum[:])
+}
+
+// TokenHashWith - Hashes a token with the key a row was created with, ErrUnknownKey once it's retired
+func TokenHashWith(id, token string) ([]byte, error) {
+	if id == "" {
+		sum := sha256.Sum256([]byte(token))
+		return sum[:], nil
+	}
+	k, err := find(id)
+	if err != nil {
+		return nil, err
+	}
+	return mac(k.token, []byte(token)), nil
+}
+
+// IDs - IDs of the known keys, plus "" for hashes made without one
+func IDs() []string {
+	ids := make([]string, 0, len(keys)+1)
+	for _, k := range keys {
+		ids = append(ids, k.ID)
+	}
+	return append(ids, "")
+}
+
+// Password - Peppers a password before it's handed to argon2id (unchanged for key "")
+func Password(id, password string) (string, error) {
+	if id == "" {
+		return password, nil
+	}
+	k, err := find(id)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(mac(k.password, []byte(password))), nil
+}
+
+// ErrUnknownKey - The hash was made with a key that's no longer in PEPPER_KEYS (retired too early)
+var ErrUnknownKey = errors.New("pepper: unknown key id")
+
+func find(id string) (Key, error) {
+	for _, k := range keys {
+		if k.ID == id {
+			return k, nil
+		}
+	}
+	return Key{}, ErrUnknownKey
+}
+
+func mac(key, msg []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(msg)
+	return h.Sum(nil)
+}
@@ -0,0 +1,47 @@
+package sms
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+var ErrInvalidPhone = errors.New("sms: invalid phone number")
+
+// NormalizePhone - Turns a user-typed number into E.164 (+ & 8-15 digits).
+// Numbers without a "+" or "00" prefix get SMS_DEFAULT_COUNTRY_CODE (their trunk "0" is dropped).
+func NormalizePhone(raw string) (string, error) {
+	s := strings.TrimSpace(raw)
+
+	// Drop common separators
+	s = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')', '/':
+			return -1
+		}
+		return r
+	}, s)
+
+	switch {
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	case strings.HasPrefix(s, "00"):
+		s = s[2:]
+	default:
+		cc := strings.TrimPrefix(os.Getenv("SMS_DEFAULT_COUNTRY_CODE"), "+")
+		if cc == "" {
+			return "", ErrInvalidPhone
+		}
+		s = cc + strings.TrimPrefix(s, "0")
+	}
+
+	if len(s) < 8 || len(s) > 15 || s[0] == '0' {
+		return "", ErrInvalidPhone
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return "", ErrInvalidPhone
+		}
+	}
+	return "+" + s, nil
+}
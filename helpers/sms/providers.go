@@ -0,0 +1,129 @@
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 15 * time.Second}
+
+// TwilioProvider - Sends through Twilio's Messages API
+type TwilioProvider struct {
+	AccountSID string
+	AuthToken  string
+	From       string
+}
+
+func (p *TwilioProvider) Send(ctx context.Context, to string, body string) error {
+	form := url.Values{
+		"To":   {to},
+		"From": {p.From},
+		"Body": {body},
+	}
+	endpoint := "https://api.twilio.com/2010-04-01/Accounts/" + url.PathEscape(p.AccountSID) + "/Messages.json"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(p.AccountSID, p.AuthToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("sms: twilio answered %d: %s", res.StatusCode, msg)
+	}
+	return nil
+}
+
+// VonageProvider - Sends through Vonage's (Nexmo) SMS API
+type VonageProvider struct {
+	APIKey    string
+	APISecret string
+	From      string
+}
+
+func (p *VonageProvider) Send(ctx context.Context, to string, body string) error {
+	form := url.Values{
+		"api_key":    {p.APIKey},
+		"api_secret": {p.APISecret},
+		"from":       {p.From},
+		"to":         {strings.TrimPrefix(to, "+")},
+		"text":       {body},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://rest.nexmo.com/sms/json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = res.Body.Close()
+	}()
+	if res.StatusCode >= 300 {
+		return fmt.Errorf("sms: vonage answered %d", res.StatusCode)
+	}
+
+	// Vonage answers 200 even when a message is rejected, the status is per message
+	var out struct {
+		Messages []struct {
+			Status    string `json:"status"`
+			ErrorText string `json:"error-text"`
+		} `json:"messages"`
+	}
+	if err = json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return err
+	}
+	for _, m := range out.Messages {
+		if m.Status != "0" {
+			return fmt.Errorf("sms: vonage rejected the message (%s): %s", m.Status, m.ErrorText)
+		}
+	}
+	return nil
+}
+
+// FileProvider - Appends messages to Path, or writes them to the log when Path is empty.
+// Meant for local development & tests.
+type FileProvider struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (p *FileProvider) Send(_ context.Context, to string, body string) error {
+	line := fmt.Sprintf("%s\tto=%s\t%s\n", time.Now().UTC().Format(time.RFC3339), to, body)
+	if p.Path == "" {
+		log.Print("[sms] " + line)
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f, err := os.OpenFile(p.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.WriteString(line); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,61 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Provider - Something that can deliver a text message to an E.164 number
+type Provider interface {
+	Send(ctx context.Context, to string, body string) error
+}
+
+var provider Provider = &FileProvider{}
+
+// Load - Picks the provider from SMS_PROVIDER: "twilio", "vonage", "file" or "log" (the default in development)
+func Load() error {
+	switch name := os.Getenv("SMS_PROVIDER"); name {
+	case "twilio":
+		p := &TwilioProvider{
+			AccountSID: os.Getenv("TWILIO_ACCOUNT_SID"),
+			AuthToken:  os.Getenv("TWILIO_AUTH_TOKEN"),
+			From:       os.Getenv("TWILIO_FROM"),
+		}
+		if p.AccountSID == "" || p.AuthToken == "" || p.From == "" {
+			return fmt.Errorf("sms: TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN & TWILIO_FROM are required")
+		}
+		provider = p
+	case "vonage":
+		p := &VonageProvider{
+			APIKey:    os.Getenv("VONAGE_API_KEY"),
+			APISecret: os.Getenv("VONAGE_API_SECRET"),
+			From:      os.Getenv("VONAGE_FROM"),
+		}
+		if p.APIKey == "" || p.APISecret == "" || p.From == "" {
+			return fmt.Errorf("sms: VONAGE_API_KEY, VONAGE_API_SECRET & VONAGE_FROM are required")
+		}
+		provider = p
+	case "file":
+		provider = &FileProvider{Path: os.Getenv("SMS_FILE_PATH")}
+	case "":
+		// Codes would only be printed, that's fine in development only
+		if os.Getenv("APP_ENVIRONMENT") != "dev" {
+			return fmt.Errorf("sms: SMS_PROVIDER is required (use \"log\" to print messages on purpose)")
+		}
+		provider = &FileProvider{}
+	case "log":
+		provider = &FileProvider{}
+	default:
+		return fmt.Errorf("sms: unknown SMS_PROVIDER %q", name)
+	}
+	return nil
+}
+
+// Send - Sends a text message with the configured provider
+func Send(to string, body string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	return provider.Send(ctx, to, body)
+}
@@ -0,0 +1,74 @@
+package users
+
+import (
+	"database/sql"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// emailProvider - How a mail provider maps addresses to mailboxes
+type emailProvider struct {
+	domain string // The domain its aliases are folded into
+	dots   bool   // Dots in the local part are ignored
+}
+
+// emailProviders - Providers known to deliver user+tag@ to user@ (& their alias domains).
+// Other domains keep their "+tag", it may well be a different mailbox there.
+var emailProviders = map[string]emailProvider{
+	"gmail.com":      {domain: "gmail.com", dots: true},
+	"googlemail.com": {domain: "gmail.com", dots: true},
+	"outlook.com":    {domain: "outlook.com"},
+	"hotmail.com":    {domain: "hotmail.com"},
+	"live.com":       {domain: "live.com"},
+	"msn.com":        {domain: "msn.com"},
+	"icloud.com":     {domain: "icloud.com"},
+	"me.com":         {domain: "icloud.com"},
+	"mac.com":        {domain: "icloud.com"},
+	"proton.me":      {domain: "proton.me"},
+	"protonmail.com": {domain: "proton.me"},
+	"protonmail.ch":  {domain: "proton.me"},
+	"pm.me":          {domain: "proton.me"},
+	"fastmail.com":   {domain: "fastmail.com"},
+	"fastmail.fm":    {domain: "fastmail.com"},
+	"yandex.ru":      {domain: "yandex.ru"},
+	"yandex.com":     {domain: "yandex.ru"},
+	"ya.ru":          {domain: "yandex.ru"},
+	"zoho.com":       {domain: "zoho.com"},
+}
+
+// CanonicalEmail - The mailbox an address delivers to, used to spot the same person behind different spellings:
+// lowercase, punycode domain, & for known providers no "+tag", alias domains folded & (Gmail) no dots.
+// user_emails.canonical_email holds it.
+func CanonicalEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return email
+	}
+	local, domain := email[:at], strings.TrimSuffix(email[at+1:], ".")
+
+	// Internationalized domains are compared in their ASCII form (bücher.de & xn--bcher-kva.de are the same)
+	if ascii, err := idna.Lookup.ToASCII(domain); err == nil {
+		domain = ascii
+	}
+
+	if p, ok := emailProviders[domain]; ok {
+		if i := strings.IndexByte(local, '+'); i > 0 {
+			local = local[:i]
+		}
+		if p.dots {
+			local = strings.ReplaceAll(local, ".", "")
+		}
+		domain = p.domain
+	}
+	return local + "@" + domain
+}
+
+// LockEmail - Serializes the transactions claiming the same mailbox (however it's spelled) until tx ends.
+// canonical_email can't be unique (older accounts may share a mailbox), so the "is it taken" check must run
+// after the lock, in the same transaction as the write.
+func LockEmail(tx *sql.Tx, email string) error {
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtextextended($1, 0))`, "email:"+CanonicalEmail(email))
+	return err
+}
@@ -0,0 +1,68 @@
+package users
+
+import "testing"
+
+func TestCanonicalEmail(t *testing.T) {
+	tests := []struct {
+		name  string
+		email string
+		want  string
+	}{
+		{"plain", "jane@example.com", "jane@example.com"},
+		{"case & spaces", "  Jane.Doe@Example.COM ", "jane.doe@example.com"},
+		{"trailing dot", "jane@example.com.", "jane@example.com"},
+
+		// Other domains keep tags & dots, it may be another mailbox there
+		{"unknown domain keeps the tag", "jane+news@example.com", "jane+news@example.com"},
+		{"unknown domain keeps dots", "j.ane@example.com", "j.ane@example.com"},
+
+		{"gmail tag", "jane+news@gmail.com", "jane@gmail.com"},
+		{"gmail dots", "j.a.n.e@gmail.com", "jane@gmail.com"},
+		{"gmail dots & tag", "J.ane+a+b@GMail.com", "jane@gmail.com"},
+		{"googlemail folds into gmail", "j.ane+x@googlemail.com", "jane@gmail.com"},
+		{"outlook tag, dots kept", "j.ane+x@outlook.com", "j.ane@outlook.com"},
+		{"me.com folds into icloud", "jane+x@me.com", "jane@icloud.com"},
+		{"mac.com folds into icloud", "jane@mac.com", "jane@icloud.com"},
+		{"pm.me folds into proton", "jane+x@pm.me", "jane@proton.me"},
+		{"protonmail.ch folds into proton", "jane@protonmail.ch", "jane@proton.me"},
+		{"fastmail.fm folds into fastmail", "jane+x@fastmail.fm", "jane@fastmail.com"},
+		{"ya.ru folds into yandex", "jane@ya.ru", "jane@yandex.ru"},
+		{"provider domain with trailing dot", "jane+x@gmail.com.", "jane@gmail.com"},
+		{"tag only local part kept", "+news@gmail.com", "+news@gmail.com"},
+
+		{"IDN domain to punycode", "jane@bücher.de", "jane@xn--bcher-kva.de"},
+		{"IDN uppercase", "jane@BÜCHER.de", "jane@xn--bcher-kva.de"},
+		{"punycode unchanged", "jane@xn--bcher-kva.de", "jane@xn--bcher-kva.de"},
+		{"last @ splits", "\"a@b\"@example.com", "\"a@b\"@example.com"},
+
+		{"no @", "Jane", "jane"},
+		{"no local part", "@gmail.com", "@gmail.com"},
+		{"no domain", "jane+x@", "jane+x@"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CanonicalEmail(tt.email); got != tt.want {
+				t.Fatalf("CanonicalEmail(%q) = %q, want %q", tt.email, got, tt.want)
+			}
+		})
+	}
+}
+
+// Every alias must point to a domain that's itself in the table with the same rules,
+// otherwise an address & its alias don't canonicalize to the same mailbox
+func TestEmailProvidersFoldConsistently(t *testing.T) {
+	for alias, p := range emailProviders {
+		target, ok := emailProviders[p.domain]
+		if !ok {
+			t.Errorf("%s folds into %s, which isn't a provider", alias, p.domain)
+			continue
+		}
+		if target.domain != p.domain || target.dots != p.dots {
+			t.Errorf("%s (%+v) & %s (%+v) disagree", alias, p, p.domain, target)
+		}
+		if got, want := CanonicalEmail("a.b+c@"+alias), CanonicalEmail("a.b+c@"+p.domain); got != want {
+			t.Errorf("%s gives %s, %s gives %s", alias, got, p.domain, want)
+		}
+	}
+}
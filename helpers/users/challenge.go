@@ -0,0 +1,123 @@
+package users
+
+import (
+	"app/helpers/pepper"
+	"crypto/hmac"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// Challenge kinds (sms_challenges.kind)
+const (
+	ChallengePhone    = "phone"     // Verifying a new phone number
+	ChallengeTwoFA    = "2fa"       // Second step of a password login
+	ChallengeSMSLogin = "sms_login" // Passwordless login by SMS
+	ChallengeReauth   = "reauth"    // Step-up re-authentication
+)
+
+const (
+	challengeTTL         = 10 * time.Minute
+	challengeMaxAttempts = 5
+)
+
+var (
+	ErrChallengeNotFound = errors.New("users: challenge not found")
+	ErrChallengeExpired  = errors.New("users: challenge expired")
+	ErrWrongCode         = errors.New("users: wrong code")
+)
+
+// Challenge - A pending SMS code
+type Challenge struct {
+	UserID int64
+	Kind   string
+	Phone  string
+}
+
+// NewChallenge - Creates a challenge (replacing the user's pending one of the same kind).
+// Returns the challenge token for the client & the code to text to the phone.
+func NewChallenge(db *sql.DB, userID int64, kind, phone string) (token string, code string, err error) {
+	token, err = gonanoid.New(64)
+	if err != nil {
+		return "", "", err
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", "", err
+	}
+	code = fmt.Sprintf("%06d", n.Int64())
+
+	_, err = db.Exec(`
+		INSERT INTO sms_challenges (user_id, kind, phone, token_hash, code_hash, token_key_id)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id, kind)
+		DO UPDATE SET phone = EXCLUDED.phone,
+		              token_hash = EXCLUDED.token_hash,
+		              code_hash = EXCLUDED.code_hash,
+		              token_key_id = EXCLUDED.token_key_id,
+		              attempts = 0,
+		              created_at = NOW()
+		`, userID, kind, phone, HashToken(token), HashToken(token+":"+code), TokenKeyID())
+	if err != nil {
+		return "", "", err
+	}
+	return token, code, nil
+}
+
+// LastChallengeAt - When the user's pending challenge of this kind was created (zero if none)
+func LastChallengeAt(db *sql.DB, userID int64, kind string) (time.Time, error) {
+	var at time.Time
+	err := db.QueryRow(`SELECT created_at FROM sms_challenges WHERE user_id = $1 AND kind = $2`, userID, kind).Scan(&at)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return at, err
+}
+
+// CheckChallenge - Consumes the challenge (of one of the kinds) if the code matches.
+// A challenge dies after 10 minutes or 5 wrong codes.
+func CheckChallenge(db *sql.DB, token, code string, kinds ...string) (Challenge, error) {
+	var c Challenge
+	var keyID string
+	var codeHash []byte
+	var attempts int
+	var createdAt time.Time
+	keyIDs, hashes := TokenHashes(token)
+	err := db.QueryRow(`
+		UPDATE sms_challenges
+		SET attempts = attempts + 1
+		WHERE (token_key_id, token_hash) IN (SELECT * FROM UNNEST($1::varchar[], $2::bytea[])) AND kind = ANY($3)
+		RETURNING user_id, kind, phone, token_key_id, code_hash, attempts, created_at
+		`, keyIDs, hashes, kinds).Scan(&c.UserID, &c.Kind, &c.Phone, &keyID, &codeHash, &attempts, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c, ErrChallengeNotFound
+	}
+	if err != nil {
+		return c, err
+	}
+
+	// The code is hashed with the key the challenge was created with
+	expired := time.Since(createdAt) > challengeTTL || attempts > challengeMaxAttempts
+	want, err := pepper.TokenHashWith(keyID, token+":"+code)
+	if err != nil {
+		return c, err
+	}
+	match := hmac.Equal(want, codeHash)
+	if expired || match {
+		if _, err = db.Exec(`DELETE FROM sms_challenges WHERE user_id = $1 AND kind = $2`, c.UserID, c.Kind); err != nil {
+			return c, err
+		}
+	}
+	switch {
+	case expired:
+		return c, ErrChallengeExpired
+	case !match:
+		return c, ErrWrongCode
+	}
+	return c, nil
+}
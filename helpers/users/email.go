@@ -0,0 +1,44 @@
+package users
+
+import (
+	"database/sql"
+	"os"
+	"strconv"
+	"time"
+)
+
+// EmailRevertDays - How long the previous address can undo an email change (EMAIL_REVERT_DAYS, default 7).
+// The previous address stays reserved for the same time.
+func EmailRevertDays() int {
+	if v, err := strconv.Atoi(os.Getenv("EMAIL_REVERT_DAYS")); err == nil && v > 0 {
+		return v
+	}
+	return 7
+}
+
+// EmailRevertPeriod - EmailRevertDays as a duration
+func EmailRevertPeriod() time.Duration {
+	return time.Duration(EmailRevertDays()) * 24 * time.Hour
+}
+
+// ReplacePrimaryEmail - Makes email the user's (verified) primary address in place of the current one.
+// users.email mirrors the primary row of user_emails.
+func ReplacePrimaryEmail(tx *sql.Tx, userID int64, email string) error {
+	// The address may already be one of the user's extra ones
+	_, err := tx.Exec(`DELETE FROM user_emails WHERE user_id = $1 AND email = $2 AND NOT is_primary`, userID, email)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE user_emails
+		SET email = $2, canonical_email = $3, verified = TRUE, verified_at = NOW()
+		WHERE user_id = $1 AND is_primary
+		`, userID, email, CanonicalEmail(email))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE users SET email = $2, email_verified = TRUE WHERE id = $1`, userID, email)
+	return err
+}
@@ -0,0 +1,203 @@
+package users
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Email domain rule names (returned to the client when an address is rejected)
+const (
+	EmailRuleNotAllowed = "domain_not_allowed"
+	EmailRuleBlocked    = "domain_blocked"
+	EmailRuleDisposable = "disposable"
+	EmailRuleNoMX       = "no_mx"
+)
+
+//go:embed disposable_domains.txt
+var disposableDomainList string
+
+// mxTimeout - How long the MX lookup may take, an unanswered lookup lets the address through
+const mxTimeout = 3 * time.Second
+
+// MXResolver - Tells whether a domain can receive email (tests stub it with UseMXResolver)
+type MXResolver interface {
+	AcceptsMail(ctx context.Context, domain string) (bool, error)
+}
+
+// DNSResolver - Looks up MX records, falling back to A/AAAA records (RFC 5321 implicit MX)
+type DNSResolver struct {
+	Resolver *net.Resolver
+}
+
+func (d DNSResolver) AcceptsMail(ctx context.Context, domain string) (bool, error) {
+	res := d.Resolver
+	if res == nil {
+		res = net.DefaultResolver
+	}
+
+	mx, err := res.LookupMX(ctx, domain)
+	if err == nil {
+		// A single "." record is a null MX (RFC 7505), the domain takes no mail
+		if len(mx) == 1 && mx[0].Host == "." {
+			return false, nil
+		}
+		return len(mx) > 0, nil
+	}
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		return false, err
+	}
+
+	hosts, err := res.LookupHost(ctx, domain)
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return len(hosts) > 0, nil
+}
+
+// EmailDomainPolicy - Which domains addresses may use. Every list also covers subdomains.
+type EmailDomainPolicy struct {
+	Allowed    map[string]struct{} // When not empty, the only domains accepted (they skip the other checks)
+	Denied     map[string]struct{}
+	Disposable map[string]struct{}
+	MX         MXResolver // nil skips the MX check
+}
+
+// DefaultEmailDomainPolicy - Any domain but the bundled disposable ones, no MX check
+func DefaultEmailDomainPolicy() EmailDomainPolicy {
+	p := EmailDomainPolicy{
+		Allowed:    map[string]struct{}{},
+		Denied:     map[string]struct{}{},
+		Disposable: map[string]struct{}{},
+	}
+	for _, line := range strings.Split(disposableDomainList, "\n") {
+		addDomain(p.Disposable, line)
+	}
+	return p
+}
+
+var emailDomainPolicy = DefaultEmailDomainPolicy()
+
+// LoadEmailDomainPolicy - Applies EMAIL_DOMAIN_ALLOWLIST & EMAIL_DOMAIN_DENYLIST (comma separated),
+// EMAIL_DOMAIN_DENYLIST_FILE, EMAIL_ALLOW_DISPOSABLE & EMAIL_MX_CHECK
+func LoadEmailDomainPolicy() error {
+	p := DefaultEmailDomainPolicy()
+
+	for _, d := range strings.Split(os.Getenv("EMAIL_DOMAIN_ALLOWLIST"), ",") {
+		addDomain(p.Allowed, d)
+	}
+	for _, d := range strings.Split(os.Getenv("EMAIL_DOMAIN_DENYLIST"), ",") {
+		addDomain(p.Denied, d)
+	}
+
+	// Extra denied domains (one per line)
+	if path := os.Getenv("EMAIL_DOMAIN_DENYLIST_FILE"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			addDomain(p.Denied, sc.Text())
+		}
+		_ = f.Close()
+		if err = sc.Err(); err != nil {
+			return err
+		}
+	}
+
+	if s := os.Getenv("EMAIL_ALLOW_DISPOSABLE"); s != "" {
+		allow, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		if allow {
+			p.Disposable = map[string]struct{}{}
+		}
+	}
+	if s := os.Getenv("EMAIL_MX_CHECK"); s != "" {
+		check, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		if check {
+			p.MX = DNSResolver{}
+		}
+	}
+
+	emailDomainPolicy = p
+	return nil
+}
+
+// UseMXResolver - Replaces the MX check's resolver (nil turns the check off)
+func UseMXResolver(r MXResolver) {
+	emailDomainPolicy.MX = r
+}
+
+// CheckEmailDomain - Returns the rule the address's domain breaks, "" if it's fine.
+// A failed MX lookup (timeout, SERVFAIL...) lets the address through.
+func CheckEmailDomain(ctx context.Context, email string) string {
+	p := emailDomainPolicy
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return EmailRuleNotAllowed
+	}
+	domain := strings.TrimSuffix(strings.ToLower(email[at+1:]), ".")
+
+	if len(p.Allowed) > 0 {
+		if matchDomain(p.Allowed, domain) {
+			return ""
+		}
+		return EmailRuleNotAllowed
+	}
+	if matchDomain(p.Denied, domain) {
+		return EmailRuleBlocked
+	}
+	if matchDomain(p.Disposable, domain) {
+		return EmailRuleDisposable
+	}
+
+	if p.MX != nil {
+		ctx, cancel := context.WithTimeout(ctx, mxTimeout)
+		defer cancel()
+		if ok, err := p.MX.AcceptsMail(ctx, domain); err == nil && !ok {
+			return EmailRuleNoMX
+		}
+	}
+	return ""
+}
+
+// matchDomain - Whether the domain or one of its parents is in the set
+func matchDomain(set map[string]struct{}, domain string) bool {
+	for domain != "" {
+		if _, ok := set[domain]; ok {
+			return true
+		}
+		_, parent, found := strings.Cut(domain, ".")
+		if !found {
+			return false
+		}
+		domain = parent
+	}
+	return false
+}
+
+func addDomain(set map[string]struct{}, line string) {
+	d := strings.ToLower(strings.TrimSpace(line))
+	d = strings.TrimPrefix(strings.TrimSuffix(d, "."), "@")
+	if d == "" || strings.HasPrefix(d, "#") {
+		return
+	}
+	set[d] = struct{}{}
+}
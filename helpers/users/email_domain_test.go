@@ -0,0 +1,205 @@
+package users
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// stubMX - Answers the MX check from a map, unknown domains fail the lookup
+type stubMX map[string]bool
+
+func (s stubMX) AcceptsMail(_ context.Context, domain string) (bool, error) {
+	ok, found := s[domain]
+	if !found {
+		return false, errors.New("lookup timed out")
+	}
+	return ok, nil
+}
+
+// usePolicy - Swaps the email domain policy for the length of the test
+func usePolicy(t *testing.T, p EmailDomainPolicy) {
+	t.Helper()
+	old := emailDomainPolicy
+	t.Cleanup(func() { emailDomainPolicy = old })
+	emailDomainPolicy = p
+}
+
+func domains(names ...string) map[string]struct{} {
+	set := map[string]struct{}{}
+	for _, n := range names {
+		addDomain(set, n)
+	}
+	return set
+}
+
+func TestCheckEmailDomain(t *testing.T) {
+	mx := stubMX{"example.com": true, "sub.example.com": true, "nomail.example": false}
+
+	tests := []struct {
+		name   string
+		policy EmailDomainPolicy
+		email  string
+		want   string
+	}{
+		{"no rules", EmailDomainPolicy{}, "jane@example.com", ""},
+		{"no domain", EmailDomainPolicy{}, "jane", EmailRuleNotAllowed},
+
+		{"allowed", EmailDomainPolicy{Allowed: domains("example.com")}, "jane@example.com", ""},
+		{"allowed subdomain", EmailDomainPolicy{Allowed: domains("example.com")}, "jane@eng.example.com", ""},
+		{"allowed, case & trailing dot", EmailDomainPolicy{Allowed: domains("Example.COM")}, "jane@EXAMPLE.com.", ""},
+		{"not allowed", EmailDomainPolicy{Allowed: domains("example.com")}, "jane@example.org", EmailRuleNotAllowed},
+		{"suffix is not a subdomain", EmailDomainPolicy{Allowed: domains("example.com")}, "jane@badexample.com", EmailRuleNotAllowed},
+		{"allowed skips the other checks", EmailDomainPolicy{
+			Allowed:    domains("example.com"),
+			Denied:     domains("example.com"),
+			Disposable: domains("example.com"),
+			MX:         mx,
+		}, "jane@nomail.example.com", ""},
+
+		{"denied", EmailDomainPolicy{Denied: domains("spam.example")}, "jane@spam.example", EmailRuleBlocked},
+		{"denied subdomain", EmailDomainPolicy{Denied: domains("spam.example")}, "jane@a.b.spam.example", EmailRuleBlocked},
+		{"denied parent only", EmailDomainPolicy{Denied: domains("a.spam.example")}, "jane@spam.example", ""},
+		{"denylist comments", EmailDomainPolicy{Denied: domains("# spam.example", "@other.example")}, "jane@other.example", EmailRuleBlocked},
+
+		{"disposable", DefaultEmailDomainPolicy(), "jane@mailinator.com", EmailRuleDisposable},
+		{"disposable subdomain", DefaultEmailDomainPolicy(), "jane@x.mailinator.com", EmailRuleDisposable},
+		{"denied before disposable", EmailDomainPolicy{
+			Denied:     domains("mailinator.com"),
+			Disposable: domains("mailinator.com"),
+		}, "jane@mailinator.com", EmailRuleBlocked},
+
+		{"mx accepts", EmailDomainPolicy{MX: mx}, "jane@sub.example.com", ""},
+		{"mx refuses", EmailDomainPolicy{MX: mx}, "jane@nomail.example", EmailRuleNoMX},
+		{"mx lookup fails", EmailDomainPolicy{MX: mx}, "jane@unknown.example", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usePolicy(t, tt.policy)
+			if got := CheckEmailDomain(context.Background(), tt.email); got != tt.want {
+				t.Fatalf("CheckEmailDomain(%q) = %q, want %q", tt.email, got, tt.want)
+			}
+		})
+	}
+}
+
+// fakeDNS - A UDP DNS server on localhost answering from the zone, and a resolver using it
+func fakeDNS(t *testing.T) *net.Resolver {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = pc.Close()
+	})
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var q dnsmessage.Message
+			if q.Unpack(buf[:n]) != nil || len(q.Questions) != 1 {
+				continue
+			}
+			answer := dnsAnswer(q)
+			resp, err := answer.Pack()
+			if err != nil {
+				continue
+			}
+			_, _ = pc.WriteTo(resp, addr)
+		}
+	}()
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", pc.LocalAddr().String())
+		},
+	}
+}
+
+// dnsAnswer - The zone: mail.test (MX), null.test (null MX), implicit.test (A only),
+// servfail.test (server failure), anything else doesn't exist
+func dnsAnswer(q dnsmessage.Message) dnsmessage.Message {
+	question := q.Questions[0]
+	resp := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: q.ID, Response: true, Authoritative: true, RecursionAvailable: true},
+		Questions: q.Questions,
+	}
+	header := dnsmessage.ResourceHeader{Name: question.Name, Type: question.Type, Class: dnsmessage.ClassINET, TTL: 60}
+	mxHost := func(host string) dnsmessage.Resource {
+		return dnsmessage.Resource{Header: header, Body: &dnsmessage.MXResource{Pref: 10, MX: dnsmessage.MustNewName(host)}}
+	}
+
+	switch question.Name.String() {
+	case "mail.test.":
+		if question.Type == dnsmessage.TypeMX {
+			resp.Answers = append(resp.Answers, mxHost("mx.mail.test."))
+		}
+	case "null.test.":
+		if question.Type == dnsmessage.TypeMX {
+			resp.Answers = append(resp.Answers, mxHost("."))
+		}
+	case "implicit.test.":
+		if question.Type == dnsmessage.TypeA {
+			resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}})
+		}
+	case "servfail.test.":
+		resp.RCode = dnsmessage.RCodeServerFailure
+	default:
+		resp.RCode = dnsmessage.RCodeNameError
+	}
+	return resp
+}
+
+func TestDNSResolverAcceptsMail(t *testing.T) {
+	r := DNSResolver{Resolver: fakeDNS(t)}
+
+	tests := []struct {
+		domain  string
+		want    bool
+		wantErr bool
+	}{
+		{"mail.test", true, false},
+		{"null.test", false, false},     // RFC 7505 null MX
+		{"implicit.test", true, false},  // No MX, falls back to the A record
+		{"nxdomain.test", false, false}, // NXDOMAIN
+		{"servfail.test", false, true},  // Lookup error, CheckEmailDomain lets it through
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			got, err := r.AcceptsMail(ctx, tt.domain)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want an error: %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("AcceptsMail(%q) = %v, want %v", tt.domain, got, tt.want)
+			}
+		})
+	}
+
+	// End to end through the policy
+	usePolicy(t, EmailDomainPolicy{MX: r})
+	for email, want := range map[string]string{
+		"jane@mail.test":     "",
+		"jane@null.test":     EmailRuleNoMX,
+		"jane@nxdomain.test": EmailRuleNoMX,
+		"jane@servfail.test": "",
+	} {
+		if got := CheckEmailDomain(context.Background(), email); got != want {
+			t.Errorf("CheckEmailDomain(%q) = %q, want %q", email, got, want)
+		}
+	}
+}
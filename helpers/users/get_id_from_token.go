@@ -1,30 +1,46 @@
 package users
 
 import (
-	"crypto/sha256"
+	"app/helpers/pepper"
 	"database/sql"
 	"errors"
 	"net/http"
 )
 
-// HashToken - Hashes the token using SHA256
+// HashToken - Hashes the token with the current pepper key (HMAC-SHA256)
 func HashToken(token string) []byte {
-	sum := sha256.Sum256([]byte(token))
-	return sum[:]
+	return pepper.TokenHash(token)
+}
+
+// TokenHashes - Hashes of the token under every known pepper key & the key IDs they pair with, for lookups:
+// (token_key_id, token_hash) IN (SELECT * FROM UNNEST($1::varchar[], $2::bytea[]))
+func TokenHashes(token string) ([]string, [][]byte) {
+	return pepper.TokenHashes(token)
+}
+
+// TokenKeyID - ID of the pepper key HashToken uses, stored next to the hash
+func TokenKeyID() string {
+	return pepper.CurrentID()
 }
 
 // GetId - Gets the user's ID by their session token
 func GetId(rawToken string, w http.ResponseWriter, db *sql.DB) (int64, error) {
-	token := HashToken(rawToken)
-
+	// Only active accounts can use their sessions.
+	// Sessions hashed with an older pepper key are moved to the current one.
 	var userID int64
+	keyIDs, hashes := TokenHashes(rawToken)
 	err := db.QueryRow(`
-		UPDATE sessions
-		SET last_used_at = NOW()
-		WHERE token_hash = $1
-		  AND last_used_at >= NOW() - INTERVAL '1 week'
-		RETURNING user_id
-	`, token).Scan(&userID)
+		UPDATE sessions s
+		SET last_used_at = NOW(),
+		    token_hash = $3,
+		    token_key_id = $4
+		FROM users u
+		WHERE (s.token_key_id, s.token_hash) IN (SELECT * FROM UNNEST($1::varchar[], $2::bytea[]))
+		  AND s.last_used_at >= NOW() - INTERVAL '1 week'
+		  AND u.id = s.user_id
+		  AND u.status = 'active'
+		RETURNING s.user_id
+	`, keyIDs, hashes, HashToken(rawToken), TokenKeyID()).Scan(&userID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			w.WriteHeader(http.StatusUnauthorized)
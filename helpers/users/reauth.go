@@ -0,0 +1,32 @@
+package users
+
+import (
+	"database/sql"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ReauthWindow - How long a password confirmation unlocks sensitive actions (REAUTH_WINDOW_MINUTES, default 10)
+func ReauthWindow() time.Duration {
+	if v, err := strconv.Atoi(os.Getenv("REAUTH_WINDOW_MINUTES")); err == nil && v > 0 {
+		return time.Duration(v) * time.Minute
+	}
+	return 10 * time.Minute
+}
+
+// MarkReauthenticated - Stamps the session as having just confirmed the password
+func MarkReauthenticated(db *sql.DB, rawToken string) error {
+	keyIDs, hashes := TokenHashes(rawToken)
+	res, err := db.Exec(`
+		UPDATE sessions SET reauthenticated_at = NOW()
+		WHERE (token_key_id, token_hash) IN (SELECT * FROM UNNEST($1::varchar[], $2::bytea[]))
+		`, keyIDs, hashes)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
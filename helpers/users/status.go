@@ -0,0 +1,92 @@
+package users
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Account statuses (users.status)
+const (
+	StatusActive     = "active"     // Can log in & use sessions
+	StatusUnverified = "unverified" // Registered, email not verified yet
+	StatusSuspended  = "suspended"  // Blocked by an admin
+	StatusLocked     = "locked"     // Blocked for security reasons, cleared by a password reset
+	StatusDeleted    = "deleted"    // Deleted, waiting to be purged
+)
+
+var ErrInvalidStatus = errors.New("users: invalid status")
+
+// ValidStatus - Checks if s is a known account status
+func ValidStatus(s string) bool {
+	switch s {
+	case StatusActive, StatusUnverified, StatusSuspended, StatusLocked, StatusDeleted:
+		return true
+	}
+	return false
+}
+
+// SetStatus - Moves the user to a new status & revokes their sessions unless they stay usable
+func SetStatus(db *sql.DB, userID int64, status, reason string) error {
+	if !ValidStatus(status) {
+		return ErrInvalidStatus
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if err = SetStatusTx(tx, userID, status, reason); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SetStatusTx - SetStatus inside the caller's transaction
+func SetStatusTx(tx *sql.Tx, userID int64, status, reason string) error {
+	if !ValidStatus(status) {
+		return ErrInvalidStatus
+	}
+
+	res, err := tx.Exec(`
+		UPDATE users
+		SET status = $1,
+		    status_reason = NULLIF($2, ''),
+		    status_changed_at = NOW(),
+		    deleted_at = CASE WHEN $1 = 'deleted' THEN COALESCE(deleted_at, NOW()) ELSE NULL END
+		WHERE id = $3 AND purged_at IS NULL
+		`, status, reason, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if status != StatusActive {
+		_, err = tx.Exec(`DELETE FROM sessions WHERE user_id = $1`, userID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeletionGraceDays - Days a deleted account can be restored before it's purged (ACCOUNT_DELETION_GRACE_DAYS, default 30)
+func DeletionGraceDays() int {
+	if v, err := strconv.Atoi(os.Getenv("ACCOUNT_DELETION_GRACE_DAYS")); err == nil && v >= 0 {
+		return v
+	}
+	return 30
+}
+
+// DeletionGracePeriod - DeletionGraceDays as a duration
+func DeletionGracePeriod() time.Duration {
+	return time.Duration(DeletionGraceDays()) * 24 * time.Hour
+}
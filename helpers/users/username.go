@@ -0,0 +1,125 @@
+package users
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Username rule names (returned to the client when a username is rejected)
+const (
+	UsernameRuleLength     = "length"
+	UsernameRuleCharacters = "characters"
+	UsernameRuleReserved   = "reserved"
+)
+
+// UsernamePolicy - What a username may look like. Usernames are compared case-insensitively.
+type UsernamePolicy struct {
+	MinLength int
+	MaxLength int
+	Pattern   *regexp.Regexp
+	Reserved  map[string]struct{}
+}
+
+// defaultReservedUsernames - Names that could be mistaken for the service itself or clash with routes
+var defaultReservedUsernames = []string{
+	"about", "abuse", "account", "accounts", "admin", "administrator", "api", "app", "auth",
+	"billing", "blog", "contact", "dashboard", "dev", "docs", "email", "help", "hostmaster",
+	"info", "login", "logout", "mail", "me", "moderator", "no-reply", "noreply", "null",
+	"official", "owner", "postmaster", "privacy", "profile", "register", "root", "security",
+	"settings", "signin", "signup", "staff", "status", "support", "system", "team", "terms",
+	"undefined", "user", "users", "webmaster", "www",
+}
+
+// DefaultUsernamePolicy - 3-32 letters, digits, "_", "." or "-", starting with a letter or digit
+func DefaultUsernamePolicy() UsernamePolicy {
+	p := UsernamePolicy{
+		MinLength: 3,
+		MaxLength: 32,
+		Pattern:   regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`),
+		Reserved:  map[string]struct{}{},
+	}
+	for _, w := range defaultReservedUsernames {
+		p.Reserved[w] = struct{}{}
+	}
+	return p
+}
+
+var usernamePolicy = DefaultUsernamePolicy()
+
+// usernameColumnLength - Size of users.username, the longest username that can be stored
+const usernameColumnLength = 64
+
+// LoadUsernamePolicy - Applies USERNAME_MIN_LENGTH, USERNAME_MAX_LENGTH, USERNAME_PATTERN & USERNAME_RESERVED_FILE
+func LoadUsernamePolicy() error {
+	p := DefaultUsernamePolicy()
+
+	if s := os.Getenv("USERNAME_MIN_LENGTH"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		p.MinLength = v
+	}
+	if s := os.Getenv("USERNAME_MAX_LENGTH"); s != "" {
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return err
+		}
+		p.MaxLength = v
+	}
+	if s := os.Getenv("USERNAME_PATTERN"); s != "" {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return err
+		}
+		p.Pattern = re
+	}
+
+	// users.username is VARCHAR(64)
+	if p.MinLength < 1 || p.MaxLength > usernameColumnLength || p.MinLength > p.MaxLength {
+		return fmt.Errorf("USERNAME_MIN_LENGTH & USERNAME_MAX_LENGTH must be within 1-%d (min <= max)", usernameColumnLength)
+	}
+
+	// Extra reserved words (one per line)
+	if path := os.Getenv("USERNAME_RESERVED_FILE"); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			line := strings.ToLower(strings.TrimSpace(sc.Text()))
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			p.Reserved[line] = struct{}{}
+		}
+		_ = f.Close()
+		if err = sc.Err(); err != nil {
+			return err
+		}
+	}
+
+	usernamePolicy = p
+	return nil
+}
+
+// CheckUsername - Returns the rule the username breaks, "" if it's fine.
+// An "@" is never allowed so logins can tell usernames & emails apart.
+func CheckUsername(username string) string {
+	n := len([]rune(username))
+	if n < usernamePolicy.MinLength || n > usernamePolicy.MaxLength {
+		return UsernameRuleLength
+	}
+	if strings.Contains(username, "@") || !usernamePolicy.Pattern.MatchString(username) {
+		return UsernameRuleCharacters
+	}
+	if _, ok := usernamePolicy.Reserved[strings.ToLower(username)]; ok {
+		return UsernameRuleReserved
+	}
+	return ""
+}
@@ -0,0 +1,85 @@
+package jobs
+
+import (
+	"app/helpers/logs"
+	"app/helpers/users"
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// canonicalBatchSize - Addresses canonicalized per transaction
+const canonicalBatchSize = 500
+
+// canonicalColumns - The canonical columns to backfill & the address each is computed from
+var canonicalColumns = []struct{ table, source, column string }{
+	{"user_emails", "email", "canonical_email"},
+	{"email_change_reverts", "old_email", "old_canonical_email"},
+}
+
+// BackfillCanonicalEmails - Fills user_emails.canonical_email & email_change_reverts.old_canonical_email for rows
+// stored before they existed. Returns once every row has them (or ctx is cancelled), lookups fall back to the exact
+// address meanwhile.
+func BackfillCanonicalEmails(ctx context.Context, db *sql.DB) {
+	for _, c := range canonicalColumns {
+		for ctx.Err() == nil {
+			n, err := backfillCanonicalEmails(ctx, db, c.table, c.source, c.column)
+			if err != nil {
+				if ctx.Err() == nil {
+					logs.Err(db, "Canonical email backfill", "Failed to backfill canonical emails", err, map[string]any{"table": c.table}, 0)
+				}
+				return
+			}
+			if n < canonicalBatchSize {
+				break
+			}
+		}
+	}
+}
+
+// backfillCanonicalEmails - Canonicalizes a batch of table.source into table.column (names from canonicalColumns)
+func backfillCanonicalEmails(ctx context.Context, db *sql.DB, table, source, column string) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	type address struct {
+		id    string
+		email string
+	}
+	var batch []address
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`
+		SELECT id, %[2]s FROM %[1]s
+		WHERE %[3]s IS NULL
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+		`, table, source, column), canonicalBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	for rows.Next() {
+		var a address
+		if err = rows.Scan(&a.id, &a.email); err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		batch = append(batch, a)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	update := fmt.Sprintf(`UPDATE %s SET %s = $1 WHERE id = $2`, table, column)
+	for _, a := range batch {
+		_, err = tx.ExecContext(ctx, update, users.CanonicalEmail(a.email), a.id)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(batch), tx.Commit()
+}
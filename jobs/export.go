@@ -0,0 +1,215 @@
+package jobs
+
+import (
+	email2 "app/helpers/email"
+	"app/helpers/logs"
+	"app/helpers/users"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	gonanoid "github.com/matoous/go-nanoid/v2"
+)
+
+// ExportLinkHours - How long a data export download link stays valid (DATA_EXPORT_LINK_HOURS, default 48)
+func ExportLinkHours() int {
+	if v, err := strconv.Atoi(os.Getenv("DATA_EXPORT_LINK_HOURS")); err == nil && v > 0 {
+		return v
+	}
+	return 48
+}
+
+// exportSections - Everything we hold about a user, one query per archive section.
+// Each query returns a single JSON value.
+var exportSections = []struct {
+	name  string
+	query string
+}{
+	{"profile", `
+		SELECT to_jsonb(t) FROM (
+			SELECT id::text AS id, name, username, email, email_verified, phone, sms_2fa_enabled, locale,
+			       status, status_reason,
+			       status_changed_at, deleted_at
+			FROM users WHERE id = $1
+		) t`},
+	{"emails", `
+		SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.created_at), '[]'::jsonb) FROM (
+			SELECT email, verified, is_primary, verified_at, created_at
+			FROM user_emails WHERE user_id = $1
+		) t`},
+	{"sessions", `
+		SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.created_at), '[]'::jsonb) FROM (
+			SELECT id::text AS id, created_at, last_used_at
+			FROM sessions WHERE user_id = $1
+		) t`},
+	{"pending_email_change", `
+		SELECT to_jsonb(t) FROM (
+			SELECT new_email, created_at
+			FROM email_change_tokens WHERE user_id = $1
+		) t`},
+	{"previous_emails", `
+		SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.created_at), '[]'::jsonb) FROM (
+			SELECT old_email, new_email, created_at
+			FROM email_change_reverts WHERE user_id = $1
+		) t`},
+	{"password_changes", `
+		SELECT COALESCE(jsonb_agg(created_at ORDER BY created_at), '[]'::jsonb)
+		FROM password_history WHERE user_id = $1`},
+	{"security_events", `
+		SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.created_at), '[]'::jsonb) FROM (
+			SELECT event_type, ip, user_agent, metadata, created_at
+			FROM audit_events WHERE subject_id = $1
+		) t`},
+	{"errors", `
+		SELECT COALESCE(jsonb_agg(to_jsonb(t) ORDER BY t.created_at), '[]'::jsonb) FROM (
+			SELECT id, name, message, context, created_at
+			FROM errors WHERE user_id = $1
+		) t`},
+}
+
+// ProcessDataExports - Builds pending data exports & emails their download links.
+// Runs until ctx is cancelled.
+func ProcessDataExports(ctx context.Context, db *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Drop archives whose link expired
+		_, err := db.ExecContext(ctx, `
+			UPDATE data_exports
+			SET status = 'expired', archive = NULL
+			WHERE status = 'ready' AND expires_at < NOW()`)
+		if err != nil && ctx.Err() == nil {
+			logs.Err(db, "Data export job", "Failed to expire old exports", err, nil, 0)
+		}
+
+		// Build everything that's pending
+		for ctx.Err() == nil {
+			done, err := processNextExport(ctx, db)
+			if err != nil || done {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// processNextExport - Builds the oldest pending export, done=true when there's nothing left
+func processNextExport(ctx context.Context, db *sql.DB) (done bool, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// Claim an export
+	var exportID string
+	var userID int64
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, user_id
+		FROM data_exports
+		WHERE status = 'pending'
+		ORDER BY created_at
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED`).Scan(&exportID, &userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	fail := func(stage string, err error) (bool, error) {
+		logs.Err(
+			db,
+			"Data export job",
+			"Failed to build the data export",
+			err,
+			map[string]any{
+				"export_id": exportID,
+				"stage":     stage,
+			},
+			userID,
+		)
+		// The transaction may be aborted, mark the export outside of it
+		_ = tx.Rollback()
+		_, err = db.ExecContext(ctx, `UPDATE data_exports SET status = 'failed', completed_at = NOW() WHERE id = $1`, exportID)
+		return false, err
+	}
+
+	// Assemble the archive
+	archive := map[string]any{
+		"generated_at": time.Now().UTC(),
+	}
+	for _, s := range exportSections {
+		var section []byte
+		err = tx.QueryRowContext(ctx, s.query, userID).Scan(&section)
+		if errors.Is(err, sql.ErrNoRows) {
+			archive[s.name] = nil
+			continue
+		}
+		if err != nil {
+			return fail(s.name, err)
+		}
+		archive[s.name] = json.RawMessage(section)
+	}
+	raw, err := json.Marshal(archive)
+	if err != nil {
+		return fail("marshal", err)
+	}
+
+	// Generate the download token & hash it
+	rawToken, err := gonanoid.New(128)
+	if err != nil {
+		return fail("token", err)
+	}
+
+	// Store the archive
+	hours := ExportLinkHours()
+	_, err = tx.ExecContext(ctx, `
+		UPDATE data_exports
+		SET status = 'ready',
+		    archive = $1::jsonb,
+		    token_hash = $2,
+		    token_key_id = $3,
+		    completed_at = NOW(),
+		    expires_at = NOW() + make_interval(hours => $4)
+		WHERE id = $5
+		`, string(raw), users.HashToken(rawToken), users.TokenKeyID(), hours, exportID)
+	if err != nil {
+		return fail("store", err)
+	}
+
+	// Get the user's email
+	var email string
+	err = tx.QueryRowContext(ctx, `SELECT email FROM users WHERE id = $1`, userID).Scan(&email)
+	if err != nil {
+		return fail("email lookup", err)
+	}
+
+	// Queue the download link in the same transaction, the outbox retries it if the send fails
+	frontend := os.Getenv("FRONTEND_URL")
+	u := fmt.Sprintf("%s/profile/export?token=%s", frontend, url.PathEscape(rawToken))
+	if err = email2.SendDataExport(tx, userID, email, u, hours); err != nil {
+		return fail("queue email", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+
+	return false, nil
+}
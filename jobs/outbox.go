@@ -0,0 +1,204 @@
+package jobs
+
+import (
+	email2 "app/helpers/email"
+	"app/helpers/logs"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// outboxLease - How long a claimed email is hidden from other workers (a crashed send is retried after it)
+	outboxLease = 5 * time.Minute
+
+	// outboxBaseBackoff & outboxMaxBackoff - Retry n waits base * 2^(n-1), capped
+	outboxBaseBackoff = 30 * time.Second
+	outboxMaxBackoff  = 6 * time.Hour
+
+	// outboxSendTimeout - A single delivery attempt, independent of shutdown so in-flight sends finish
+	outboxSendTimeout = 30 * time.Second
+)
+
+// OutboxWorkers - Concurrent senders (EMAIL_OUTBOX_WORKERS, default 4)
+func OutboxWorkers() int {
+	if v, err := strconv.Atoi(os.Getenv("EMAIL_OUTBOX_WORKERS")); err == nil && v > 0 {
+		return v
+	}
+	return 4
+}
+
+// OutboxMaxAttempts - Attempts before an email is marked dead (EMAIL_MAX_ATTEMPTS, default 8)
+func OutboxMaxAttempts() int {
+	if v, err := strconv.Atoi(os.Getenv("EMAIL_MAX_ATTEMPTS")); err == nil && v > 0 {
+		return v
+	}
+	return 8
+}
+
+type outboxEmail struct {
+	id       int64
+	userID   int64
+	to       string
+	locale   string
+	template string
+	data     map[string]any
+	attempts int
+}
+
+// SendQueuedEmails - Sends the email outbox with a pool of workers, retrying failures with exponential backoff.
+// Runs until ctx is cancelled, then waits for the emails already claimed to be sent.
+func SendQueuedEmails(ctx context.Context, db *sql.DB, interval time.Duration) {
+	workers := OutboxWorkers()
+	queue := make(chan outboxEmail)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for e := range queue {
+				deliverQueuedEmail(db, e)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(queue)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Hand out everything that's due
+		for ctx.Err() == nil {
+			batch, err := claimQueuedEmails(ctx, db, workers)
+			if err != nil {
+				if ctx.Err() == nil {
+					logs.Err(db, "Email outbox job", "Failed to claim queued emails", err, nil, 0)
+				}
+				break
+			}
+			for _, e := range batch {
+				queue <- e
+			}
+			if len(batch) < workers {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// claimQueuedEmails - Takes up to n due emails & pushes their next attempt past the lease
+func claimQueuedEmails(ctx context.Context, db *sql.DB, n int) ([]outboxEmail, error) {
+	rows, err := db.QueryContext(ctx, `
+		UPDATE email_outbox
+		SET attempts = attempts + 1,
+		    next_attempt_at = NOW() + make_interval(secs => $1)
+		WHERE id IN (
+			SELECT id FROM email_outbox
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, COALESCE(user_id, 0), recipient, locale, template, data, attempts
+		`, outboxLease.Seconds(), n)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	var batch []outboxEmail
+	for rows.Next() {
+		var e outboxEmail
+		var data []byte
+		if err = rows.Scan(&e.id, &e.userID, &e.to, &e.locale, &e.template, &data, &e.attempts); err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(data, &e.data); err != nil {
+			return nil, err
+		}
+		batch = append(batch, e)
+	}
+	return batch, rows.Err()
+}
+
+// deliverQueuedEmail - Sends one email & records the outcome (sent, skipped, retry later or dead)
+func deliverQueuedEmail(db *sql.DB, e outboxEmail) {
+	ctx, cancel := context.WithTimeout(context.Background(), outboxSendTimeout)
+	defer cancel()
+
+	// Never send to an address that bounced or complained
+	suppressed, err := email2.IsSuppressed(db, e.to)
+	if err != nil {
+		logs.Err(db, "Email outbox job", "Failed to check the suppression list", err, map[string]any{"outbox_id": e.id}, e.userID)
+	} else if suppressed {
+		_, err = db.Exec(`UPDATE email_outbox SET status = 'skipped', data = '{}'::jsonb, last_error = 'suppressed' WHERE id = $1`, e.id)
+		if err != nil {
+			logs.Err(db, "Email outbox job", "Failed to mark the email as skipped", err, map[string]any{"outbox_id": e.id}, e.userID)
+		}
+		return
+	}
+
+	sendErr := email2.Deliver(ctx, e.to, e.locale, e.template, e.data)
+	if sendErr == nil {
+		// The data holds links with raw tokens, don't keep it around
+		_, err = db.Exec(`UPDATE email_outbox SET status = 'sent', sent_at = NOW(), data = '{}'::jsonb, last_error = NULL WHERE id = $1`, e.id)
+		if err != nil {
+			logs.Err(db, "Email outbox job", "Failed to mark the email as sent", err, map[string]any{"outbox_id": e.id}, e.userID)
+		}
+		return
+	}
+
+	if e.attempts >= OutboxMaxAttempts() {
+		// Only what's needed to investigate, the data (with its raw token links) is dropped like for sent emails
+		logs.Err(
+			db,
+			"Email outbox job",
+			"Gave up sending the email",
+			sendErr,
+			map[string]any{
+				"outbox_id": e.id,
+				"template":  e.template,
+				"attempts":  e.attempts,
+			},
+			e.userID,
+		)
+		_, err = db.Exec(`UPDATE email_outbox SET status = 'dead', data = '{}'::jsonb, last_error = $1 WHERE id = $2`, sendErr.Error(), e.id)
+		if err != nil {
+			logs.Err(db, "Email outbox job", "Failed to mark the email as dead", err, map[string]any{"outbox_id": e.id}, e.userID)
+		}
+		return
+	}
+
+	_, err = db.Exec(`
+		UPDATE email_outbox
+		SET last_error = $1, next_attempt_at = NOW() + make_interval(secs => $2)
+		WHERE id = $3
+		`, sendErr.Error(), outboxBackoff(e.attempts).Seconds(), e.id)
+	if err != nil {
+		logs.Err(db, "Email outbox job", "Failed to reschedule the email", err, map[string]any{"outbox_id": e.id}, e.userID)
+	}
+}
+
+// outboxBackoff - Wait before the attempt following attempt n
+func outboxBackoff(n int) time.Duration {
+	d := time.Duration(float64(outboxBaseBackoff) * math.Pow(2, float64(n-1)))
+	if d <= 0 || d > outboxMaxBackoff {
+		return outboxMaxBackoff
+	}
+	return d
+}
@@ -0,0 +1,198 @@
+package jobs
+
+import (
+	"app/helpers/logs"
+	"app/helpers/users"
+	"context"
+	"database/sql"
+	"os"
+	"time"
+)
+
+// PurgeDeletedUsers - Periodically removes accounts that were deleted longer than the grace period ago.
+// ACCOUNT_PURGE_MODE picks between hard-deleting the row ("delete", default) & anonymizing it ("anonymize").
+// Runs until ctx is cancelled.
+func PurgeDeletedUsers(ctx context.Context, db *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purgeDeletedUsers(ctx, db)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func purgeDeletedUsers(ctx context.Context, db *sql.DB) {
+	anonymize := os.Getenv("ACCOUNT_PURGE_MODE") == "anonymize"
+
+	// Forget old addresses once their revert window is over
+	_, err := db.ExecContext(ctx, `
+		DELETE FROM email_change_reverts
+		WHERE created_at < NOW() - make_interval(days => $1)
+		`, users.EmailRevertDays())
+	if err != nil && ctx.Err() == nil {
+		logs.Err(db, "Purge job", "Failed to delete expired email reverts", err, nil, 0)
+	}
+
+	// Forget sent, skipped & dead emails after a month
+	_, err = db.ExecContext(ctx, `
+		DELETE FROM email_outbox
+		WHERE status <> 'pending' AND created_at < NOW() - INTERVAL '30 days'
+		`)
+	if err != nil && ctx.Err() == nil {
+		logs.Err(db, "Purge job", "Failed to delete old outbox emails", err, nil, 0)
+	}
+
+	// Find accounts past the grace period
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, email
+		FROM users
+		WHERE status = 'deleted'
+		  AND purged_at IS NULL
+		  AND deleted_at < NOW() - make_interval(days => $1)
+		LIMIT 500
+		`, users.DeletionGraceDays())
+	if err != nil {
+		if ctx.Err() == nil {
+			logs.Err(db, "Purge job", "Failed to query deleted users", err, nil, 0)
+		}
+		return
+	}
+	type victim struct {
+		id    int64
+		email string
+	}
+	var victims []victim
+	for rows.Next() {
+		var v victim
+		if err = rows.Scan(&v.id, &v.email); err != nil {
+			break
+		}
+		victims = append(victims, v)
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	_ = rows.Close()
+	if err != nil {
+		logs.Err(db, "Purge job", "Failed to read deleted users", err, nil, 0)
+		return
+	}
+
+	for _, v := range victims {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := purgeUser(ctx, db, v.id, v.email, anonymize); err != nil {
+			logs.Err(
+				db,
+				"Purge job",
+				"Failed to purge a deleted user",
+				err,
+				map[string]any{
+					"anonymize": anonymize,
+				},
+				v.id,
+			)
+		}
+	}
+}
+
+// purgeUser - Removes (or anonymizes) a single user & everything referencing them
+func purgeUser(ctx context.Context, db *sql.DB, userID int64, email string, anonymize bool) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// Error logs have no FK, scrub them by ID & by any address (or the username) the user had stored in their context,
+	// including rows logged without a user (failed logins, password resets...)
+	_, err = tx.ExecContext(ctx, `
+		WITH addresses AS (
+			SELECT LOWER($2::text) AS email
+			UNION SELECT LOWER(email) FROM user_emails WHERE user_id = $1
+			UNION SELECT LOWER(old_email) FROM email_change_reverts WHERE user_id = $1
+			UNION SELECT LOWER(new_email) FROM email_change_reverts WHERE user_id = $1
+			UNION SELECT LOWER(new_email) FROM email_change_tokens WHERE user_id = $1
+		)
+		UPDATE errors
+		SET user_id = NULL, context = NULL
+		WHERE user_id = $1
+		   OR LOWER(context->>'email') IN (SELECT email FROM addresses)
+		   OR LOWER(context->>'old_email') IN (SELECT email FROM addresses)
+		   OR LOWER(context->>'new_email') IN (SELECT email FROM addresses)
+		   OR LOWER(context->>'current_email') IN (SELECT email FROM addresses)
+		   OR LOWER(context->>'username') = (SELECT LOWER(username) FROM users WHERE id = $1)
+		`, userID, email)
+	if err != nil {
+		return err
+	}
+
+	// The audit log has no FK either, drop the user's events & unlink what they did to others
+	_, err = tx.ExecContext(ctx, `DELETE FROM audit_events WHERE subject_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE audit_events SET actor_id = NULL WHERE actor_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	if !anonymize {
+		// Sessions, tokens & history cascade
+		_, err = tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, userID)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+
+	// Keep the row (& its ID) but drop everything personal
+	for _, q := range []string{
+		`DELETE FROM sessions WHERE user_id = $1`,
+		`DELETE FROM verification_tokens WHERE user_id = $1`,
+		`DELETE FROM reset_tokens WHERE user_id = $1`,
+		`DELETE FROM email_change_tokens WHERE user_id = $1`,
+		`DELETE FROM account_restore_tokens WHERE user_id = $1`,
+		`DELETE FROM security_lock_tokens WHERE user_id = $1`,
+		`DELETE FROM email_change_reverts WHERE user_id = $1`,
+		`DELETE FROM user_emails WHERE user_id = $1`,
+		`DELETE FROM sms_challenges WHERE user_id = $1`,
+		`DELETE FROM password_history WHERE user_id = $1`,
+		`DELETE FROM data_exports WHERE user_id = $1`,
+		`DELETE FROM email_outbox WHERE user_id = $1`,
+	} {
+		if _, err = tx.ExecContext(ctx, q, userID); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users
+		SET name = '',
+		    username = NULL,
+		    phone = NULL,
+		    sms_2fa_enabled = FALSE,
+		    email = 'deleted-' || id || '@invalid',
+		    password_hash = '',
+		    password_algo = 'argon2id',
+		    password_key_id = '',
+		    email_verified = FALSE,
+		    is_admin = FALSE,
+		    status_reason = 'purged',
+		    purged_at = NOW()
+		WHERE id = $1
+		`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
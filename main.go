@@ -1,6 +1,13 @@
 package main
 
 import (
+	"app/helpers/email"
+	"app/helpers/password"
+	"app/helpers/pepper"
+	"app/helpers/sms"
+	"app/helpers/users"
+	"app/jobs"
+	"app/mw"
 	"app/routes"
 	"app/utils"
 	"bufio"
@@ -188,11 +195,70 @@ func main() {
 	}
 	step("OK", "ENV ready.")
 
+	// Pepper keys
+	if err := pepper.Load(); err != nil {
+		fail(err.Error())
+		os.Exit(1)
+	}
+	if pepper.CurrentID() == "" {
+		warn("PEPPER_KEYS not set, token & password hashes are not peppered.")
+	}
+
+	// Password policy
+	info("Loading password policy...")
+	if err := password.LoadPolicy(); err != nil {
+		fail("Failed to load the password policy: " + err.Error())
+		os.Exit(1)
+	}
+	if err := password.LoadParams(); err != nil {
+		fail("Invalid argon2id parameters: " + err.Error())
+		os.Exit(1)
+	}
+	step("OK", "Password policy ready.")
+
+	// Username policy
+	if err := users.LoadUsernamePolicy(); err != nil {
+		fail("Failed to load the username policy: " + err.Error())
+		os.Exit(1)
+	}
+
+	// Email domain policy
+	if err := users.LoadEmailDomainPolicy(); err != nil {
+		fail("Failed to load the email domain policy: " + err.Error())
+		os.Exit(1)
+	}
+
+	// Proxies whose forwarded client IPs are believed (rate limits & audit log)
+	if err := mw.LoadTrustedProxies(); err != nil {
+		fail(err.Error())
+		os.Exit(1)
+	}
+
+	// Mail transport
+	if err := email.Load(); err != nil {
+		fail("Failed to set up email: " + err.Error())
+		os.Exit(1)
+	}
+
+	// SMS provider
+	if err := sms.Load(); err != nil {
+		fail("Failed to set up SMS: " + err.Error())
+		os.Exit(1)
+	}
+
 	// DB
 	info("Connecting to DB...")
 	db := utils.InitDb()
 	step("OK", "DB connected.")
 
+	// Passwords peppered with a key that was removed from PEPPER_KEYS can't be verified anymore
+	var retired int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM users WHERE password_key_id <> ALL($1)`, pepper.IDs()).Scan(&retired); err != nil {
+		warn("Failed to check for retired pepper keys: " + err.Error())
+	} else if retired > 0 {
+		warn(fmt.Sprintf("%d password hashes use a pepper key missing from PEPPER_KEYS, those users must reset their password.", retired))
+	}
+
 	// Router
 	info("Initializing Routes...")
 	r := routes.NewRouter(db, sf)
@@ -233,6 +299,18 @@ func main() {
 		Handler: r,
 	}
 
+	// Background jobs
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	go jobs.PurgeDeletedUsers(jobsCtx, db, time.Hour)
+	go jobs.ProcessDataExports(jobsCtx, db, 15*time.Second)
+	go jobs.BackfillCanonicalEmails(jobsCtx, db)
+	outboxDone := make(chan struct{})
+	go func() {
+		jobs.SendQueuedEmails(jobsCtx, db, 2*time.Second)
+		close(outboxDone)
+	}()
+
 	info(fmt.Sprintf("Starting server on :%s", port))
 	go func() {
 		time.Sleep(150 * time.Millisecond)
@@ -248,6 +326,7 @@ func main() {
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 	<-stop
 	warn("Shutdown signal received.")
+	stopJobs()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -256,4 +335,13 @@ func main() {
 	} else {
 		step("OK", "Server stopped.")
 	}
+
+	// Let the emails being sent finish (the rest stay queued)
+	select {
+	case <-outboxDone:
+		step("OK", "Email outbox drained.")
+	case <-time.After(45 * time.Second):
+		warn("Gave up waiting for in-flight emails.")
+	}
+	_ = email.Close()
 }
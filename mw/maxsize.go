@@ -7,7 +7,7 @@ func MaxBody(n int64) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			switch r.Method {
-			case http.MethodPost, http.MethodPut, http.MethodPatch:
+			case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
 				if r.ContentLength > n && r.ContentLength != -1 {
 					w.WriteHeader(http.StatusRequestEntityTooLarge) // 413
 					return
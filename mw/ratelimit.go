@@ -0,0 +1,68 @@
+package mw
+
+import (
+	"app/helpers/audit"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limiter - Allows n events per key every window. Counts are kept in memory, so each instance limits on its own.
+type Limiter struct {
+	n      int
+	window time.Duration
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	count int
+	reset time.Time
+}
+
+func NewLimiter(n int, window time.Duration) *Limiter {
+	return &Limiter{n: n, window: window, buckets: map[string]*bucket{}, lastSweep: time.Now()}
+}
+
+// Allow - Counts an event for the key, false (& how long until the next one is allowed) above the limit
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Forget expired buckets now & then
+	if now.Sub(l.lastSweep) > l.window {
+		for k, b := range l.buckets {
+			if now.After(b.reset) {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+	b, ok := l.buckets[key]
+	if !ok || now.After(b.reset) {
+		b = &bucket{reset: now.Add(l.window)}
+		l.buckets[key] = b
+	}
+	b.count++
+	return b.count <= l.n, b.reset.Sub(now)
+}
+
+// RateLimit - Allows n requests per client IP every window, answers 429 above that.
+// The IP is the connection's, or the one a trusted proxy forwarded (see RealIP), so spoofed headers don't get a new bucket.
+func RateLimit(n int, window time.Duration) func(http.Handler) http.Handler {
+	l := NewLimiter(n, window)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if allowed, retry := l.Allow(audit.ClientIP(r)); !allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(retry.Seconds())+1))
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
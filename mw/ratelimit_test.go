@@ -0,0 +1,114 @@
+package mw
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// useTrustedProxies - Sets TRUSTED_PROXIES for the length of the test
+func useTrustedProxies(t *testing.T, proxies string) {
+	t.Helper()
+	t.Setenv("TRUSTED_PROXIES", proxies)
+	old := trustedProxies
+	t.Cleanup(func() { trustedProxies = old })
+	if err := LoadTrustedProxies(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRealIP(t *testing.T) {
+	useTrustedProxies(t, "10.0.0.0/8, 192.0.2.1")
+
+	tests := []struct {
+		name       string
+		remote     string
+		forwarded  []string
+		realIP     string
+		wantRemote string
+	}{
+		{"direct", "203.0.113.5:4000", nil, "", "203.0.113.5:4000"},
+		{"untrusted peer spoofing", "203.0.113.5:4000", []string{"198.51.100.7"}, "198.51.100.8", "203.0.113.5:4000"},
+		{"trusted proxy", "10.1.2.3:4000", []string{"198.51.100.7"}, "", "198.51.100.7"},
+		{"client prepended a fake hop", "10.1.2.3:4000", []string{"1.1.1.1, 198.51.100.7"}, "", "198.51.100.7"},
+		{"proxy chain", "10.1.2.3:4000", []string{"198.51.100.7, 192.0.2.1", "10.9.9.9"}, "", "198.51.100.7"},
+		{"only proxies", "10.1.2.3:4000", []string{"10.2.2.2"}, "", "10.2.2.2"},
+		{"garbage hop", "10.1.2.3:4000", []string{"nope"}, "", "10.1.2.3:4000"},
+		{"X-Real-IP from a proxy", "192.0.2.1:4000", nil, "198.51.100.9", "198.51.100.9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			h := RealIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = r.RemoteAddr }))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remote
+			for _, f := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", f)
+			}
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+			if got != tt.wantRemote {
+				t.Fatalf("RemoteAddr = %q, want %q", got, tt.wantRemote)
+			}
+		})
+	}
+}
+
+func TestLoadTrustedProxiesRejectsGarbage(t *testing.T) {
+	old := trustedProxies
+	t.Cleanup(func() { trustedProxies = old })
+	for _, v := range []string{"10.0.0.0/33", "nope", "10.0.0.0/8,,300.1.1.1"} {
+		t.Setenv("TRUSTED_PROXIES", v)
+		if err := LoadTrustedProxies(); err == nil {
+			t.Errorf("TRUSTED_PROXIES=%q was accepted", v)
+		}
+	}
+}
+
+// A new X-Forwarded-For on every request must not get a new bucket
+func TestRateLimitIgnoresSpoofedHeaders(t *testing.T) {
+	useTrustedProxies(t, "")
+	h := RealIP(RateLimit(3, time.Minute)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "203.0.113.5:" + strconv.Itoa(4000+i)
+		req.Header.Set("X-Forwarded-For", "198.51.100."+strconv.Itoa(i))
+		req.Header.Set("X-Real-IP", "198.51.100."+strconv.Itoa(i))
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		want := http.StatusNoContent
+		if i >= 3 {
+			want = http.StatusTooManyRequests
+		}
+		if rec.Code != want {
+			t.Fatalf("request %d: %d, want %d", i, rec.Code, want)
+		}
+		if want == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+			t.Fatal("no Retry-After")
+		}
+	}
+}
+
+func TestLimiterWindow(t *testing.T) {
+	l := NewLimiter(2, 50*time.Millisecond)
+	for i, want := range []bool{true, true, false} {
+		if ok, _ := l.Allow("a"); ok != want {
+			t.Fatalf("event %d allowed = %v", i, ok)
+		}
+	}
+	if ok, _ := l.Allow("b"); !ok {
+		t.Fatal("another key shares the bucket")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("still limited after the window")
+	}
+}
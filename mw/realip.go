@@ -0,0 +1,92 @@
+package mw
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// trustedProxies - Networks whose X-Forwarded-For & X-Real-IP headers are believed
+var trustedProxies []netip.Prefix
+
+// LoadTrustedProxies - Parses TRUSTED_PROXIES (comma separated CIDRs or IPs of the load balancers in front of the API).
+// Empty trusts nobody: the connection's address is the client's, forwarded headers are ignored.
+func LoadTrustedProxies() error {
+	var prefixes []netip.Prefix
+	for _, s := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return fmt.Errorf("mw: invalid TRUSTED_PROXIES entry %q: %w", s, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return fmt.Errorf("mw: invalid TRUSTED_PROXIES entry %q: %w", s, err)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	trustedProxies = prefixes
+	return nil
+}
+
+func trusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// RealIP - Replaces RemoteAddr with the client's IP when the request comes through a trusted proxy.
+// X-Forwarded-For is read right to left, skipping trusted proxies: the first other address is the client
+// (entries further left were written by the client & can't be believed), or the leftmost one if all are proxies.
+// Falls back to X-Real-IP.
+func RealIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		peer, err := netip.ParseAddr(host)
+		if err == nil && trusted(peer) {
+			if ip, ok := forwardedFor(r); ok {
+				r.RemoteAddr = ip.String()
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedFor - The client address a trusted proxy forwarded
+func forwardedFor(r *http.Request) (netip.Addr, bool) {
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		if !trusted(ip) || i == 0 {
+			return ip.Unmap(), true
+		}
+	}
+
+	if ip, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return ip.Unmap(), true
+	}
+	return netip.Addr{}, false
+}
@@ -0,0 +1,56 @@
+package mw
+
+import (
+	"app/helpers/i18n"
+	"app/helpers/logs"
+	"app/helpers/users"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// RequireRecentAuth - Only lets the request through if its session confirmed the password
+// within the re-authentication window (see POST /v1/auth/reauthenticate)
+func RequireRecentAuth(db *sql.DB) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if token == "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			var recent bool
+			keyIDs, hashes := users.TokenHashes(token)
+			err := db.QueryRow(`
+				SELECT COALESCE(reauthenticated_at >= NOW() - make_interval(secs => $3), FALSE)
+				FROM sessions
+				WHERE (token_key_id, token_hash) IN (SELECT * FROM UNNEST($1::varchar[], $2::bytea[]))
+				`, keyIDs, hashes, users.ReauthWindow().Seconds()).Scan(&recent)
+			if err != nil {
+				if errors.Is(err, sql.ErrNoRows) {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				logs.Err(db, "DB err", "Failed to check the session's last re-authentication", err,
+					map[string]any{"method": r.Method, "path": r.URL.Path},
+					0,
+				)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			if !recent {
+				w.WriteHeader(http.StatusForbidden)
+				_ = json.NewEncoder(w).Encode(map[string]any{
+					"error":   "reauthentication_required",
+					"message": i18n.T(i18n.FromRequest(r), "error.reauthentication_required"),
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
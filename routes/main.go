@@ -6,6 +6,7 @@ import (
 	"database/sql"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,7 +19,7 @@ import (
 func NewRouter(db *sql.DB, sf *sonyflake.Sonyflake) chi.Router {
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(mw.RealIP)
 	r.Use(middleware.Logger)
 	r.Use(mw.RecoverAndLog(db))
 	r.Use(middleware.Timeout(15 * time.Second))
@@ -42,6 +43,16 @@ func NewRouter(db *sql.DB, sf *sonyflake.Sonyflake) chi.Router {
 		_, _ = w.Write([]byte("Oh~ h-hi pal!"))
 	})
 
+	// Preview of the emails sent & of the templates (development only)
+	if os.Getenv("APP_ENVIRONMENT") == "dev" {
+		r.Route("/dev/emails", func(r chi.Router) {
+			r.Get("/", handlers.DevEmailsHandler)
+			r.Post("/clear", handlers.DevClearEmailsHandler)
+			r.Get("/templates/{name}", handlers.DevEmailTemplateHandler)
+			r.Get("/{id}", handlers.DevEmailHandler)
+		})
+	}
+
 	// API v1
 	r.Route("/v1", func(r chi.Router) {
 		// Auth
@@ -67,6 +78,30 @@ func NewRouter(db *sql.DB, sf *sonyflake.Sonyflake) chi.Router {
 			// Change password
 			r.Put("/password", func(w http.ResponseWriter, r *http.Request) { handlers.PasswordChangeHandler(w, r, db) })
 
+			// Finish a 2FA or SMS login with the texted code
+			r.Post("/login/verify", func(w http.ResponseWriter, r *http.Request) { handlers.LoginChallengeHandler(w, r, sf, db) })
+
+			// Text a login code
+			r.With(mw.RateLimit(smsSendsPerMinute(), time.Minute)).Post("/login/sms", func(w http.ResponseWriter, r *http.Request) { handlers.SMSLoginHandler(w, r, db) })
+
+			// Confirm the password (or a texted code) for sensitive actions
+			r.Post("/reauthenticate", func(w http.ResponseWriter, r *http.Request) { handlers.ReauthenticateHandler(w, r, db) })
+
+			// Text a re-authentication code
+			r.With(mw.RateLimit(smsSendsPerMinute(), time.Minute)).Post("/reauthenticate/sms", func(w http.ResponseWriter, r *http.Request) { handlers.ReauthSMSHandler(w, r, db) })
+
+			// Check if a username is available
+			r.With(mw.RateLimit(usernameChecksPerMinute(), time.Minute)).Get("/username-available", func(w http.ResponseWriter, r *http.Request) { handlers.UsernameAvailableHandler(w, r, db) })
+
+			// Cancel account deletion
+			r.Put("/restore/{token}", func(w http.ResponseWriter, r *http.Request) { handlers.RestoreAccountHandler(w, r, db) })
+
+			// Lock account ("this wasn't me")
+			r.Put("/lock/{token}", func(w http.ResponseWriter, r *http.Request) { handlers.LockAccountHandler(w, r, db) })
+
+			// Undo an email change
+			r.Put("/email/revert/{token}", func(w http.ResponseWriter, r *http.Request) { handlers.RevertEmailHandler(w, r, db) })
+
 			// Verification
 			r.Route("/verifications", func(r chi.Router) {
 				// Email verification
@@ -85,13 +120,92 @@ func NewRouter(db *sql.DB, sf *sonyflake.Sonyflake) chi.Router {
 			// Update profile
 			r.Patch("/", func(w http.ResponseWriter, r *http.Request) { handlers.UpdateProfileHandler(w, r, db) })
 
+			// Delete account
+			r.With(mw.RequireRecentAuth(db)).Delete("/", func(w http.ResponseWriter, r *http.Request) { handlers.DeleteAccountHandler(w, r, db) })
+
+			// Request a data export
+			r.Post("/export", func(w http.ResponseWriter, r *http.Request) { handlers.RequestDataExportHandler(w, r, db) })
+
+			// Download a data export
+			r.Get("/export/{token}", func(w http.ResponseWriter, r *http.Request) { handlers.DownloadDataExportHandler(w, r, db) })
+
+			// List security events
+			r.Get("/security-events", func(w http.ResponseWriter, r *http.Request) { handlers.SecurityEventsHandler(w, r, db) })
+
+			// Email addresses
+			r.Route("/emails", func(r chi.Router) {
+				// List addresses
+				r.Get("/", func(w http.ResponseWriter, r *http.Request) { handlers.ListEmailsHandler(w, r, db) })
+
+				// Add an address
+				r.With(mw.RequireRecentAuth(db)).Post("/", func(w http.ResponseWriter, r *http.Request) { handlers.AddEmailHandler(w, r, db) })
+
+				// Verify an added address
+				r.Put("/verify/{token}", func(w http.ResponseWriter, r *http.Request) { handlers.VerifyEmailHandler(w, r, db) })
+
+				// Make an address primary
+				r.With(mw.RequireRecentAuth(db)).Put("/{id}/primary", func(w http.ResponseWriter, r *http.Request) { handlers.MakePrimaryEmailHandler(w, r, db) })
+
+				// Remove an address
+				r.With(mw.RequireRecentAuth(db)).Delete("/{id}", func(w http.ResponseWriter, r *http.Request) { handlers.RemoveEmailHandler(w, r, db) })
+			})
+
+			// Phone number
+			r.Route("/phone", func(r chi.Router) {
+				// Text a code to a new number
+				r.With(mw.RequireRecentAuth(db), mw.RateLimit(smsSendsPerMinute(), time.Minute)).Put("/", func(w http.ResponseWriter, r *http.Request) { handlers.SetPhoneHandler(w, r, db) })
+
+				// Save the number with the texted code
+				r.Put("/verify", func(w http.ResponseWriter, r *http.Request) { handlers.VerifyPhoneHandler(w, r, db) })
+
+				// Remove the number
+				r.With(mw.RequireRecentAuth(db)).Delete("/", func(w http.ResponseWriter, r *http.Request) { handlers.RemovePhoneHandler(w, r, db) })
+			})
+
+			// Turn SMS two-factor authentication on or off
+			r.With(mw.RequireRecentAuth(db)).Put("/2fa", func(w http.ResponseWriter, r *http.Request) { handlers.UpdateTwoFactorHandler(w, r, db) })
+
 			// Send email update confirmation
-			r.Post("/email", func(w http.ResponseWriter, r *http.Request) { handlers.RequestEmailChangeHandler(w, r, db) })
+			r.With(mw.RequireRecentAuth(db)).Post("/email", func(w http.ResponseWriter, r *http.Request) { handlers.RequestEmailChangeHandler(w, r, db) })
 
 			// Update email
 			r.Put("/email/{token}", func(w http.ResponseWriter, r *http.Request) { handlers.UpdateEmail(w, r, db) })
 		})
+
+		// Bounce & complaint notifications
+		r.Route("/webhooks/email", func(r chi.Router) {
+			// Generic format
+			r.Post("/", func(w http.ResponseWriter, r *http.Request) { handlers.EmailWebhookHandler(w, r, db) })
+
+			// Amazon SES through SNS
+			r.Post("/ses", func(w http.ResponseWriter, r *http.Request) { handlers.SESWebhookHandler(w, r, db) })
+		})
+
+		// Admin
+		r.Route("/admin", func(r chi.Router) {
+			// Update a user's account status
+			r.Put("/users/{id}/status", func(w http.ResponseWriter, r *http.Request) { handlers.UpdateUserStatusHandler(w, r, db) })
+
+			// Search the audit log
+			r.Get("/audit-events", func(w http.ResponseWriter, r *http.Request) { handlers.AuditEventsHandler(w, r, db) })
+		})
 	})
 
 	return r
 }
+
+// usernameChecksPerMinute - Username availability checks allowed per IP & minute (USERNAME_CHECKS_PER_MINUTE, default 30)
+func usernameChecksPerMinute() int {
+	if v, err := strconv.Atoi(os.Getenv("USERNAME_CHECKS_PER_MINUTE")); err == nil && v > 0 {
+		return v
+	}
+	return 30
+}
+
+// smsSendsPerMinute - Requests that text a code allowed per IP & minute (SMS_SENDS_PER_MINUTE, default 5)
+func smsSendsPerMinute() int {
+	if v, err := strconv.Atoi(os.Getenv("SMS_SENDS_PER_MINUTE")); err == nil && v > 0 {
+		return v
+	}
+	return 5
+}
@@ -0,0 +1,51 @@
+package routes
+
+import (
+	"app/mw"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// The availability check must stay limited per client even when every request claims another IP,
+// otherwise anyone can list the taken usernames
+func TestUsernameAvailabilityIsRateLimited(t *testing.T) {
+	t.Setenv("USERNAME_CHECKS_PER_MINUTE", "3")
+	t.Setenv("TRUSTED_PROXIES", "")
+	if err := mw.LoadTrustedProxies(); err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open("pgx", "postgres://127.0.0.1:1/none") // Never reached, "!" fails the policy first
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := NewRouter(db, nil)
+
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/v1/auth/username-available?username=!", nil)
+		req.RemoteAddr = "203.0.113.5:" + strconv.Itoa(4000+i)
+		req.Header.Set("X-Forwarded-For", "198.51.100."+strconv.Itoa(i))
+		req.Header.Set("X-Real-IP", "198.51.100."+strconv.Itoa(i))
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		want := http.StatusOK
+		if i >= 3 {
+			want = http.StatusTooManyRequests
+		}
+		if rec.Code != want {
+			t.Fatalf("request %d: %d, want %d", i, rec.Code, want)
+		}
+	}
+
+	// Another client still gets through
+	req := httptest.NewRequest(http.MethodGet, "/v1/auth/username-available?username=!", nil)
+	req.RemoteAddr = "203.0.113.6:4000"
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("another client: %d", rec.Code)
+	}
+}
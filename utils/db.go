@@ -2,8 +2,10 @@ package utils
 
 import (
 	"database/sql"
+	"errors"
 	"os"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
 )
 
@@ -20,3 +22,9 @@ func InitDb() *sql.DB {
 
 	return conn
 }
+
+// IsUniqueViolation - Checks if err is a unique violation (23505) of the constraint or index
+func IsUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == constraint
+}